@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/getircase/config"
+	"github.com/getircase/controller"
+	"github.com/getircase/db"
+)
+
+// commands ...
+// Subcommands run instead of the server when named as first argument
+var commands = map[string]func(args []string) error{
+	"backup":     backupCommand,
+	"restore":    restoreCommand,
+	"rotate-key": rotateKeyCommand,
+}
+
+// adminFlags ...
+// Flags shared by the subcommands talking to a running server
+func adminFlags(name string) (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	addr := fs.String("addr", "http://localhost:8080", "base url of the running server")
+	token := fs.String("token", os.Getenv("GETIR_ADMIN_TOKEN"), "admin bearer token (env GETIR_ADMIN_TOKEN)")
+	return fs, addr, token
+}
+
+// adminRequest ...
+// Calls an /admin endpoint of the running server
+func adminRequest(method, url, token string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// backupCommand ...
+// getircase backup -out file [-since version]
+// Writes a backup of the running server to a file and prints the since
+// value for the next incremental backup
+func backupCommand(args []string) error {
+	fs, addr, token := adminFlags("backup")
+	out := fs.String("out", "", "backup file to write")
+	since := fs.Uint64("since", 0, "only entries newer than this version, as printed by the previous backup")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("backup: -out is required")
+	}
+	resp, err := adminRequest("GET", fmt.Sprintf("%s/admin/backup?since=%d", strings.TrimSuffix(*addr, "/"), *since), *token, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	// the trailer is only sent once the whole backup was streamed
+	next := resp.Trailer.Get(controller.BackupSinceHeader)
+	if _, err = strconv.ParseUint(next, 10, 64); err != nil {
+		return fmt.Errorf("backup: server did not complete the backup, %s is incomplete", *out)
+	}
+	fmt.Printf("backup written to %s, next incremental backup: -since %s\n", *out, next)
+	return nil
+}
+
+// restoreCommand ...
+// getircase restore -in file
+// Loads a backup file into the running server
+func restoreCommand(args []string) error {
+	fs, addr, token := adminFlags("restore")
+	in := fs.String("in", "", "backup file to load")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return fmt.Errorf("restore: -in is required")
+	}
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	resp, err := adminRequest("POST", strings.TrimSuffix(*addr, "/")+"/admin/restore", *token, f)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Printf("restored %s\n", *in)
+	return nil
+}
+
+// rotateKeyCommand ...
+// getircase rotate-key -dir dir -old-key-file file -new-key-file file
+// Re-encrypts the disk store in dir with a new key, the server must be stopped
+// An empty old key file encrypts a plain store, an empty new key file removes the encryption
+func rotateKeyCommand(args []string) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	dir := fs.String("dir", os.Getenv("GETIR_MEMDB_DIR"), "badger data directory (env GETIR_MEMDB_DIR)")
+	oldFile := fs.String("old-key-file", os.Getenv("GETIR_MEMDB_ENCRYPTION_KEY_FILE"), "file holding the current key (env GETIR_MEMDB_ENCRYPTION_KEY_FILE)")
+	newFile := fs.String("new-key-file", "", "file holding the new key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("rotate-key: -dir is required")
+	}
+	if *oldFile == "" && *newFile == "" {
+		return fmt.Errorf("rotate-key: -old-key-file or -new-key-file is required")
+	}
+	var oldKey, newKey []byte
+	var err error
+	if *oldFile != "" {
+		if oldKey, err = config.ReadKeyFile(*oldFile); err != nil {
+			return err
+		}
+	}
+	if *newFile != "" {
+		if newKey, err = config.ReadKeyFile(*newFile); err != nil {
+			return err
+		}
+	}
+	if err = db.RotateEncryptionKey(*dir, oldKey, newKey); err != nil {
+		return fmt.Errorf("rotate-key: %v", err)
+	}
+	fmt.Printf("rotated the encryption key of %s, start the server with the new key\n", *dir)
+	return nil
+}
@@ -0,0 +1,427 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// envConfigFile ...
+// Environment variable holding the path of the JSON config file
+// used when the -config flag is not provided
+const envConfigFile = "GETIR_CONFIG"
+
+// Config ...
+// Application configuration assembled from defaults, config file,
+// environment variables and command line flags (in that order of precedence)
+type Config struct {
+	Server Server `json:"server"`
+	Mongo  Mongo  `json:"mongo"`
+	MemDB  MemDB  `json:"memdb"`
+}
+
+// Server ...
+// Listen address and timeouts of the http server
+// ShutdownTimeout bounds how long in-flight requests are drained on SIGTERM
+// AdminToken, when set, is required as bearer token on /admin endpoints
+type Server struct {
+	Addr            string   `json:"addr"`
+	ReadTimeout     Duration `json:"readTimeout"`
+	WriteTimeout    Duration `json:"writeTimeout"`
+	IdleTimeout     Duration `json:"idleTimeout"`
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	AdminToken      string   `json:"adminToken"`
+}
+
+// Mongo ...
+// Connection settings for the MongoDb records collection
+type Mongo struct {
+	URI            string   `json:"uri"`
+	Database       string   `json:"database"`
+	Collection     string   `json:"collection"`
+	ConnectTimeout Duration `json:"connectTimeout"`
+	MaxPoolSize    uint64   `json:"maxPoolSize"`
+	MinPoolSize    uint64   `json:"minPoolSize"`
+}
+
+// MemDB ...
+// Settings for the badger backed in-memory store
+// Mode "memory" keeps everything in RAM, "disk" persists under Dir and
+// runs value log GC every GCInterval (0 disables it)
+// RestoreFrom names a backup file loaded at startup
+// NamespaceQuota applies to every named namespace, Namespaces overrides it per name
+// MaxKeys and MaxBytes cap the whole store, 0 leaves it unlimited; a write over the cap
+// evicts keys by Eviction policy or fails when Eviction is "none"
+// MaxValueSize bounds the bytes of a single value, 0 leaves it unlimited
+// CompressAbove zstd compresses values larger than that many bytes, 0 disables compression
+// EncryptionKey or the content of EncryptionKeyFile encrypts the disk store with AES,
+// badger rotates its data keys every EncryptionKeyRotation; IndexCacheSize bounds the
+// memory of decrypted table indexes, 0 keeps them all
+// NumVersionsToKeep is how many versions of a key badger keeps for the key history
+type MemDB struct {
+	LogLevel       string           `json:"logLevel"`
+	Mode           string           `json:"mode"`
+	Dir            string           `json:"dir"`
+	SyncWrites     bool             `json:"syncWrites"`
+	GCInterval     Duration         `json:"gcInterval"`
+	GCDiscardRatio float64          `json:"gcDiscardRatio"`
+	RestoreFrom    string           `json:"restoreFrom"`
+	NamespaceQuota Quota            `json:"namespaceQuota"`
+	Namespaces     map[string]Quota `json:"namespaces"`
+	MaxKeys        int64            `json:"maxKeys"`
+	MaxBytes       int64            `json:"maxBytes"`
+	Eviction       string           `json:"eviction"`
+	MaxValueSize   int64            `json:"maxValueSize"`
+	CompressAbove  int64            `json:"compressAbove"`
+
+	EncryptionKey         string   `json:"encryptionKey"`
+	EncryptionKeyFile     string   `json:"encryptionKeyFile"`
+	EncryptionKeyRotation Duration `json:"encryptionKeyRotation"`
+	IndexCacheSize        int64    `json:"indexCacheSize"`
+
+	NumVersionsToKeep int `json:"numVersionsToKeep"`
+}
+
+// Quota ...
+// Upper bounds of a namespace, 0 leaves the bound unlimited
+// MaxBytes counts the bytes of keys and values
+type Quota struct {
+	MaxKeys  int64 `json:"maxKeys"`
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// QuotaFor ...
+// Returns the quota of the named namespace, the default namespace is unlimited
+func (c MemDB) QuotaFor(namespace string) Quota {
+	if namespace == "" {
+		return Quota{}
+	}
+	if q, ok := c.Namespaces[namespace]; ok {
+		return q
+	}
+	return c.NamespaceQuota
+}
+
+// Encrypted ...
+// Reports whether an encryption key is configured
+func (c MemDB) Encrypted() bool {
+	return c.EncryptionKey != "" || c.EncryptionKeyFile != ""
+}
+
+// Key ...
+// Returns the AES key encrypting the store, nil when encryption is off
+// return err if both sources are set, the key file can not be read or the key is not 16, 24 or 32 bytes
+func (c MemDB) Key() ([]byte, error) {
+	switch {
+	case c.EncryptionKey != "" && c.EncryptionKeyFile != "":
+		return nil, fmt.Errorf("config: memdb encryption key and key file are exclusive")
+	case c.EncryptionKeyFile != "":
+		return ReadKeyFile(c.EncryptionKeyFile)
+	case c.EncryptionKey != "":
+		return checkKey([]byte(c.EncryptionKey))
+	}
+	return nil, nil
+}
+
+// ReadKeyFile ...
+// Reads an AES key from path, a trailing newline is not part of the key
+func ReadKeyFile(path string) ([]byte, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	return checkKey(bytes.TrimRight(body, "\r\n"))
+}
+
+// checkKey ...
+// return err unless key has an AES key length
+func checkKey(key []byte) ([]byte, error) {
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("config: memdb encryption key must be 16, 24 or 32 bytes, got %d", len(key))
+}
+
+// MemDB modes
+const (
+	MemDBModeMemory = "memory"
+	MemDBModeDisk   = "disk"
+)
+
+// MemDB eviction policies
+// LRU evicts the least recently read or written key, oldest the least recently written one
+const (
+	EvictionNone   = "none"
+	EvictionLRU    = "lru"
+	EvictionOldest = "oldest"
+)
+
+// Default ...
+// Returns the configuration used when nothing else is provided
+// The Mongo URI has no default, credentials are never shipped in source
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:            ":8080",
+			ReadTimeout:     Duration(10 * time.Second),
+			WriteTimeout:    Duration(30 * time.Second),
+			IdleTimeout:     Duration(60 * time.Second),
+			ShutdownTimeout: Duration(15 * time.Second),
+		},
+		Mongo: Mongo{
+			Database:       "getir-case-study",
+			Collection:     "records",
+			ConnectTimeout: Duration(10 * time.Second),
+			MaxPoolSize:    100,
+		},
+		MemDB: MemDB{
+			LogLevel:       "info",
+			Mode:           MemDBModeMemory,
+			GCInterval:     Duration(5 * time.Minute),
+			GCDiscardRatio: 0.5,
+			Eviction:       EvictionNone,
+			MaxValueSize:   1 << 20,
+
+			EncryptionKeyRotation: Duration(10 * 24 * time.Hour),
+
+			NumVersionsToKeep: 1,
+		},
+	}
+}
+
+// binding ...
+// Single setting reachable through an environment variable and a flag
+type binding struct {
+	env, flag string
+}
+
+// bindings ...
+// Every setting that can be overridden from the environment
+// Flag names are registered in flagSet
+var bindings = []binding{
+	{"GETIR_SERVER_ADDR", "server-addr"},
+	{"GETIR_SERVER_READ_TIMEOUT", "server-read-timeout"},
+	{"GETIR_SERVER_WRITE_TIMEOUT", "server-write-timeout"},
+	{"GETIR_SERVER_IDLE_TIMEOUT", "server-idle-timeout"},
+	{"GETIR_SERVER_SHUTDOWN_TIMEOUT", "server-shutdown-timeout"},
+	{"GETIR_ADMIN_TOKEN", "admin-token"},
+	{"GETIR_MONGO_URI", "mongo-uri"},
+	{"GETIR_MONGO_DATABASE", "mongo-database"},
+	{"GETIR_MONGO_COLLECTION", "mongo-collection"},
+	{"GETIR_MONGO_CONNECT_TIMEOUT", "mongo-connect-timeout"},
+	{"GETIR_MONGO_MAX_POOL_SIZE", "mongo-max-pool-size"},
+	{"GETIR_MONGO_MIN_POOL_SIZE", "mongo-min-pool-size"},
+	{"GETIR_MEMDB_LOG_LEVEL", "memdb-log-level"},
+	{"GETIR_MEMDB_MODE", "memdb-mode"},
+	{"GETIR_MEMDB_DIR", "memdb-dir"},
+	{"GETIR_MEMDB_SYNC_WRITES", "memdb-sync-writes"},
+	{"GETIR_MEMDB_GC_INTERVAL", "memdb-gc-interval"},
+	{"GETIR_MEMDB_GC_DISCARD_RATIO", "memdb-gc-discard-ratio"},
+	{"GETIR_MEMDB_RESTORE_FROM", "memdb-restore-from"},
+	{"GETIR_MEMDB_NAMESPACE_MAX_KEYS", "memdb-namespace-max-keys"},
+	{"GETIR_MEMDB_NAMESPACE_MAX_BYTES", "memdb-namespace-max-bytes"},
+	{"GETIR_MEMDB_MAX_KEYS", "memdb-max-keys"},
+	{"GETIR_MEMDB_MAX_BYTES", "memdb-max-bytes"},
+	{"GETIR_MEMDB_EVICTION", "memdb-eviction"},
+	{"GETIR_MEMDB_MAX_VALUE_SIZE", "memdb-max-value-size"},
+	{"GETIR_MEMDB_COMPRESS_ABOVE", "memdb-compress-above"},
+	{"GETIR_MEMDB_ENCRYPTION_KEY", "memdb-encryption-key"},
+	{"GETIR_MEMDB_ENCRYPTION_KEY_FILE", "memdb-encryption-key-file"},
+	{"GETIR_MEMDB_ENCRYPTION_KEY_ROTATION", "memdb-encryption-key-rotation"},
+	{"GETIR_MEMDB_INDEX_CACHE_SIZE", "memdb-index-cache-size"},
+	{"GETIR_MEMDB_NUM_VERSIONS_TO_KEEP", "memdb-num-versions-to-keep"},
+}
+
+// flagSet ...
+// Binds every configurable field of c to a command line flag
+func (c *Config) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.String("config", "", "path of the JSON config file (env "+envConfigFile+")")
+	fs.StringVar(&c.Server.Addr, "server-addr", c.Server.Addr, "http listen address")
+	fs.Var(&c.Server.ReadTimeout, "server-read-timeout", "http read timeout")
+	fs.Var(&c.Server.WriteTimeout, "server-write-timeout", "http write timeout")
+	fs.Var(&c.Server.IdleTimeout, "server-idle-timeout", "http keep-alive idle timeout")
+	fs.Var(&c.Server.ShutdownTimeout, "server-shutdown-timeout", "deadline to drain in-flight requests on shutdown")
+	fs.StringVar(&c.Server.AdminToken, "admin-token", c.Server.AdminToken, "bearer token required on /admin endpoints")
+	fs.StringVar(&c.Mongo.URI, "mongo-uri", c.Mongo.URI, "MongoDb connection string")
+	fs.StringVar(&c.Mongo.Database, "mongo-database", c.Mongo.Database, "MongoDb database name")
+	fs.StringVar(&c.Mongo.Collection, "mongo-collection", c.Mongo.Collection, "MongoDb records collection name")
+	fs.Var(&c.Mongo.ConnectTimeout, "mongo-connect-timeout", "MongoDb connect timeout")
+	fs.Uint64Var(&c.Mongo.MaxPoolSize, "mongo-max-pool-size", c.Mongo.MaxPoolSize, "MongoDb connection pool upper bound, 0 for unlimited")
+	fs.Uint64Var(&c.Mongo.MinPoolSize, "mongo-min-pool-size", c.Mongo.MinPoolSize, "MongoDb connection pool lower bound")
+	fs.StringVar(&c.MemDB.LogLevel, "memdb-log-level", c.MemDB.LogLevel, "badger log level: debug, info, warning or error")
+	fs.StringVar(&c.MemDB.Mode, "memdb-mode", c.MemDB.Mode, "badger storage mode: memory or disk")
+	fs.StringVar(&c.MemDB.Dir, "memdb-dir", c.MemDB.Dir, "badger data directory, required in disk mode")
+	fs.BoolVar(&c.MemDB.SyncWrites, "memdb-sync-writes", c.MemDB.SyncWrites, "fsync every badger write in disk mode")
+	fs.Var(&c.MemDB.GCInterval, "memdb-gc-interval", "badger value log GC interval in disk mode, 0 disables it")
+	fs.Float64Var(&c.MemDB.GCDiscardRatio, "memdb-gc-discard-ratio", c.MemDB.GCDiscardRatio, "badger value log GC discard ratio")
+	fs.StringVar(&c.MemDB.RestoreFrom, "memdb-restore-from", c.MemDB.RestoreFrom, "badger backup file loaded at startup")
+	fs.Int64Var(&c.MemDB.NamespaceQuota.MaxKeys, "memdb-namespace-max-keys", c.MemDB.NamespaceQuota.MaxKeys, "keys allowed per namespace, 0 for unlimited")
+	fs.Int64Var(&c.MemDB.NamespaceQuota.MaxBytes, "memdb-namespace-max-bytes", c.MemDB.NamespaceQuota.MaxBytes, "key and value bytes allowed per namespace, 0 for unlimited")
+	fs.Int64Var(&c.MemDB.MaxKeys, "memdb-max-keys", c.MemDB.MaxKeys, "keys allowed in the whole store, 0 for unlimited")
+	fs.Int64Var(&c.MemDB.MaxBytes, "memdb-max-bytes", c.MemDB.MaxBytes, "key and value bytes allowed in the whole store, 0 for unlimited")
+	fs.StringVar(&c.MemDB.Eviction, "memdb-eviction", c.MemDB.Eviction, "policy when the store is full: none, lru or oldest")
+	fs.Int64Var(&c.MemDB.MaxValueSize, "memdb-max-value-size", c.MemDB.MaxValueSize, "bytes allowed in a single value, 0 for unlimited")
+	fs.Int64Var(&c.MemDB.CompressAbove, "memdb-compress-above", c.MemDB.CompressAbove, "zstd compress values larger than this many bytes, 0 disables compression")
+	fs.StringVar(&c.MemDB.EncryptionKey, "memdb-encryption-key", c.MemDB.EncryptionKey, "AES key of 16, 24 or 32 bytes encrypting the disk store")
+	fs.StringVar(&c.MemDB.EncryptionKeyFile, "memdb-encryption-key-file", c.MemDB.EncryptionKeyFile, "file holding the AES key encrypting the disk store")
+	fs.Var(&c.MemDB.EncryptionKeyRotation, "memdb-encryption-key-rotation", "badger data key rotation interval of an encrypted store")
+	fs.Int64Var(&c.MemDB.IndexCacheSize, "memdb-index-cache-size", c.MemDB.IndexCacheSize, "bytes of decrypted table indexes kept in memory, 0 keeps them all")
+	fs.IntVar(&c.MemDB.NumVersionsToKeep, "memdb-num-versions-to-keep", c.MemDB.NumVersionsToKeep, "versions of a key kept for the key history")
+	return fs
+}
+
+// Load ...
+// Builds the configuration from defaults, the JSON config file, environment and args
+// args are the command line arguments without the program name
+// return err if a source can not be read or the result fails validation
+func Load(name string, args []string) (*Config, error) {
+	// first pass only collects the flags given explicitly
+	fs := Default().flagSet(name)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = f.Value.String() })
+
+	cfg := Default()
+	path, ok := explicit["config"]
+	if !ok {
+		path = os.Getenv(envConfigFile)
+	}
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	// second pass applies environment then flags over the file values
+	fs = cfg.flagSet(name)
+	for _, b := range bindings {
+		if v, ok := os.LookupEnv(b.env); ok {
+			if err := fs.Set(b.flag, v); err != nil {
+				return nil, fmt.Errorf("config: invalid %s: %v", b.env, err)
+			}
+		}
+	}
+	for k, v := range explicit {
+		if err := fs.Set(k, v); err != nil {
+			return nil, fmt.Errorf("config: invalid -%s: %v", k, err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile ...
+// Overlays the JSON document at path on top of c
+func (c *Config) readFile(path string) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	if err = json.Unmarshal(body, c); err != nil {
+		return fmt.Errorf("config: %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate ...
+// Checks the configuration is usable before any connection is attempted
+func (c *Config) Validate() error {
+	if c.Server.Addr == "" {
+		return fmt.Errorf("config: server addr cannot be empty")
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		return fmt.Errorf("config: server timeouts cannot be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("config: server shutdown timeout must be positive")
+	}
+	m := c.Mongo
+	if m.URI == "" {
+		return fmt.Errorf("config: mongo uri is required (set GETIR_MONGO_URI, -mongo-uri or mongo.uri in the config file)")
+	}
+	if !strings.HasPrefix(m.URI, "mongodb://") && !strings.HasPrefix(m.URI, "mongodb+srv://") {
+		return fmt.Errorf("config: mongo uri must start with mongodb:// or mongodb+srv://")
+	}
+	if m.Database == "" {
+		return fmt.Errorf("config: mongo database cannot be empty")
+	}
+	if m.Collection == "" {
+		return fmt.Errorf("config: mongo collection cannot be empty")
+	}
+	if m.ConnectTimeout <= 0 {
+		return fmt.Errorf("config: mongo connect timeout must be positive")
+	}
+	if m.MaxPoolSize > 0 && m.MinPoolSize > m.MaxPoolSize {
+		return fmt.Errorf("config: mongo min pool size %d exceeds max pool size %d", m.MinPoolSize, m.MaxPoolSize)
+	}
+	switch c.MemDB.LogLevel {
+	case "debug", "info", "warning", "error":
+	default:
+		return fmt.Errorf("config: memdb log level %q must be debug, info, warning or error", c.MemDB.LogLevel)
+	}
+	switch c.MemDB.Mode {
+	case MemDBModeMemory:
+	case MemDBModeDisk:
+		if c.MemDB.Dir == "" {
+			return fmt.Errorf("config: memdb dir is required in disk mode (set GETIR_MEMDB_DIR or -memdb-dir)")
+		}
+	default:
+		return fmt.Errorf("config: memdb mode %q must be memory or disk", c.MemDB.Mode)
+	}
+	if c.MemDB.GCInterval < 0 {
+		return fmt.Errorf("config: memdb gc interval cannot be negative")
+	}
+	if c.MemDB.GCDiscardRatio <= 0 || c.MemDB.GCDiscardRatio >= 1 {
+		return fmt.Errorf("config: memdb gc discard ratio must be between 0 and 1")
+	}
+	if q := c.MemDB.NamespaceQuota; q.MaxKeys < 0 || q.MaxBytes < 0 {
+		return fmt.Errorf("config: memdb namespace quota cannot be negative")
+	}
+	if c.MemDB.MaxKeys < 0 || c.MemDB.MaxBytes < 0 {
+		return fmt.Errorf("config: memdb max keys and max bytes cannot be negative")
+	}
+	if c.MemDB.MaxValueSize < 0 {
+		return fmt.Errorf("config: memdb max value size cannot be negative")
+	}
+	if c.MemDB.CompressAbove < 0 {
+		return fmt.Errorf("config: memdb compress above cannot be negative")
+	}
+	if c.MemDB.Encrypted() {
+		if c.MemDB.Mode != MemDBModeDisk {
+			return fmt.Errorf("config: memdb encryption needs disk mode")
+		}
+		if _, err := c.MemDB.Key(); err != nil {
+			return err
+		}
+		if c.MemDB.EncryptionKeyRotation <= 0 {
+			return fmt.Errorf("config: memdb encryption key rotation must be positive")
+		}
+	}
+	if c.MemDB.IndexCacheSize < 0 {
+		return fmt.Errorf("config: memdb index cache size cannot be negative")
+	}
+	if c.MemDB.NumVersionsToKeep < 1 {
+		return fmt.Errorf("config: memdb num versions to keep must be at least 1")
+	}
+	switch c.MemDB.Eviction {
+	case EvictionNone, EvictionLRU, EvictionOldest:
+	default:
+		return fmt.Errorf("config: memdb eviction %q must be none, lru or oldest", c.MemDB.Eviction)
+	}
+	for name, q := range c.MemDB.Namespaces {
+		if q.MaxKeys < 0 || q.MaxBytes < 0 {
+			return fmt.Errorf("config: memdb quota of namespace %q cannot be negative", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getircase/config"
+	"github.com/stretchr/testify/require"
+)
+
+// clearEnv ...
+// Unsets GETIR_ variables for the duration of the test
+func clearEnv(t *testing.T) {
+	for _, kv := range os.Environ() {
+		k := strings.SplitN(kv, "=", 2)[0]
+		if !strings.HasPrefix(k, "GETIR_") {
+			continue
+		}
+		v := os.Getenv(k)
+		os.Unsetenv(k)
+		t.Cleanup(func() { os.Setenv(k, v) })
+	}
+}
+
+// setEnv ...
+// Sets an environment variable restored when the test ends
+func setEnv(t *testing.T, k, v string) {
+	old, ok := os.LookupEnv(k)
+	os.Setenv(k, v)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(k, old)
+		} else {
+			os.Unsetenv(k)
+		}
+	})
+}
+
+func TestLoadMissingURI(t *testing.T) {
+	clearEnv(t)
+	_, err := config.Load("test", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "mongo uri is required")
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	body := `{"mongo": {"uri": "mongodb://file", "database": "filedb", "collection": "filecol", "connectTimeout": "3s"}}`
+	require.NoError(t, ioutil.WriteFile(path, []byte(body), 0600))
+
+	// file only
+	cfg, err := config.Load("test", []string{"-config", path})
+	require.NoError(t, err)
+	require.Equal(t, "mongodb://file", cfg.Mongo.URI)
+	require.Equal(t, "filedb", cfg.Mongo.Database)
+	require.Equal(t, config.Duration(3*time.Second), cfg.Mongo.ConnectTimeout)
+	// default kept for values not in the file
+	require.Equal(t, uint64(100), cfg.Mongo.MaxPoolSize)
+
+	// environment overrides the file
+	setEnv(t, "GETIR_CONFIG", path)
+	setEnv(t, "GETIR_MONGO_DATABASE", "envdb")
+	cfg, err = config.Load("test", nil)
+	require.NoError(t, err)
+	require.Equal(t, "envdb", cfg.Mongo.Database)
+	require.Equal(t, "filecol", cfg.Mongo.Collection)
+
+	// flags override the environment
+	cfg, err = config.Load("test", []string{"-mongo-database", "flagdb", "-mongo-connect-timeout", "5s"})
+	require.NoError(t, err)
+	require.Equal(t, "flagdb", cfg.Mongo.Database)
+	require.Equal(t, config.Duration(5*time.Second), cfg.Mongo.ConnectTimeout)
+}
+
+func TestLoadValidation(t *testing.T) {
+	clearEnv(t)
+	_, err := config.Load("test", []string{"-mongo-uri", "http://nope"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "mongodb://")
+
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-mongo-min-pool-size", "10", "-mongo-max-pool-size", "5"})
+	require.Error(t, err)
+
+	setEnv(t, "GETIR_MONGO_CONNECT_TIMEOUT", "soon")
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "GETIR_MONGO_CONNECT_TIMEOUT")
+
+	// missing config file
+	_, err = config.Load("test", []string{"-config", filepath.Join(t.TempDir(), "absent.json")})
+	require.Error(t, err)
+}
+
+func TestLoadServer(t *testing.T) {
+	clearEnv(t)
+	setEnv(t, "GETIR_SERVER_SHUTDOWN_TIMEOUT", "2s")
+	cfg, err := config.Load("test", []string{"-mongo-uri", "mongodb://x", "-server-addr", ":9090"})
+	require.NoError(t, err)
+	require.Equal(t, ":9090", cfg.Server.Addr)
+	require.Equal(t, config.Duration(2*time.Second), cfg.Server.ShutdownTimeout)
+	require.Equal(t, config.Duration(30*time.Second), cfg.Server.WriteTimeout)
+
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-server-shutdown-timeout", "0s"})
+	require.Error(t, err)
+}
+
+func TestLoadMemDB(t *testing.T) {
+	clearEnv(t)
+	cfg, err := config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Equal(t, config.MemDBModeMemory, cfg.MemDB.Mode)
+
+	setEnv(t, "GETIR_MEMDB_MODE", "disk")
+	// disk mode needs a directory
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.Error(t, err)
+	cfg, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-dir", "/var/lib/getir", "-memdb-sync-writes"})
+	require.NoError(t, err)
+	require.Equal(t, "/var/lib/getir", cfg.MemDB.Dir)
+	require.True(t, cfg.MemDB.SyncWrites)
+
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-mode", "tape"})
+	require.Error(t, err)
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-mode", "memory", "-memdb-gc-discard-ratio", "1.5"})
+	require.Error(t, err)
+}
+
+func TestLoadNamespaceQuota(t *testing.T) {
+	clearEnv(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	body := `{"memdb": {"namespaces": {"dashboards": {"maxKeys": 5, "maxBytes": 1024}}}}`
+	require.NoError(t, ioutil.WriteFile(path, []byte(body), 0600))
+	setEnv(t, "GETIR_MEMDB_NAMESPACE_MAX_KEYS", "100")
+	cfg, err := config.Load("test", []string{"-config", path, "-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Equal(t, config.Quota{MaxKeys: 5, MaxBytes: 1024}, cfg.MemDB.QuotaFor("dashboards"))
+	require.Equal(t, config.Quota{MaxKeys: 100}, cfg.MemDB.QuotaFor("other"))
+	// the default namespace is unlimited
+	require.Equal(t, config.Quota{}, cfg.MemDB.QuotaFor(""))
+
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-namespace-max-bytes", "-1"})
+	require.Error(t, err)
+}
+
+func TestLoadEviction(t *testing.T) {
+	clearEnv(t)
+	cfg, err := config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Equal(t, config.EvictionNone, cfg.MemDB.Eviction)
+	require.Zero(t, cfg.MemDB.MaxKeys)
+
+	setEnv(t, "GETIR_MEMDB_EVICTION", "lru")
+	cfg, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-max-bytes", "1048576"})
+	require.NoError(t, err)
+	require.Equal(t, config.EvictionLRU, cfg.MemDB.Eviction)
+	require.Equal(t, int64(1048576), cfg.MemDB.MaxBytes)
+
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-eviction", "random"})
+	require.Error(t, err)
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-max-keys", "-1"})
+	require.Error(t, err)
+}
+
+func TestLoadMaxValueSize(t *testing.T) {
+	clearEnv(t)
+	cfg, err := config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1<<20), cfg.MemDB.MaxValueSize)
+
+	setEnv(t, "GETIR_MEMDB_MAX_VALUE_SIZE", "0")
+	cfg, err = config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Zero(t, cfg.MemDB.MaxValueSize)
+
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-max-value-size", "-1"})
+	require.Error(t, err)
+}
+
+func TestLoadCompression(t *testing.T) {
+	clearEnv(t)
+	cfg, err := config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Zero(t, cfg.MemDB.CompressAbove)
+
+	setEnv(t, "GETIR_MEMDB_COMPRESS_ABOVE", "4096")
+	cfg, err = config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Equal(t, int64(4096), cfg.MemDB.CompressAbove)
+
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-compress-above", "-1"})
+	require.Error(t, err)
+}
+
+func TestLoadEncryption(t *testing.T) {
+	clearEnv(t)
+	cfg, err := config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.False(t, cfg.MemDB.Encrypted())
+	key, err := cfg.MemDB.Key()
+	require.NoError(t, err)
+	require.Nil(t, key)
+
+	// the key comes from the environment or a file, a trailing newline is ignored
+	disk := []string{"-mongo-uri", "mongodb://x", "-memdb-mode", "disk", "-memdb-dir", t.TempDir()}
+	setEnv(t, "GETIR_MEMDB_ENCRYPTION_KEY", "0123456789abcdef")
+	cfg, err = config.Load("test", disk)
+	require.NoError(t, err)
+	key, err = cfg.MemDB.Key()
+	require.NoError(t, err)
+	require.Equal(t, []byte("0123456789abcdef"), key)
+	require.Equal(t, config.Duration(10*24*time.Hour), cfg.MemDB.EncryptionKeyRotation)
+
+	path := filepath.Join(t.TempDir(), "memdb.key")
+	require.NoError(t, ioutil.WriteFile(path, []byte("0123456789abcdef0123456789abcdef\n"), 0600))
+	setEnv(t, "GETIR_MEMDB_ENCRYPTION_KEY", "")
+	cfg, err = config.Load("test", append(disk, "-memdb-encryption-key-file", path, "-memdb-encryption-key-rotation", "24h"))
+	require.NoError(t, err)
+	key, err = cfg.MemDB.Key()
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+	require.Equal(t, config.Duration(24*time.Hour), cfg.MemDB.EncryptionKeyRotation)
+
+	// bad lengths, both sources, memory mode and missing files are refused
+	_, err = config.Load("test", append(disk, "-memdb-encryption-key", "short"))
+	require.Error(t, err)
+	_, err = config.Load("test", append(disk, "-memdb-encryption-key", "0123456789abcdef", "-memdb-encryption-key-file", path))
+	require.Error(t, err)
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-encryption-key", "0123456789abcdef"})
+	require.Error(t, err)
+	_, err = config.Load("test", append(disk, "-memdb-encryption-key-file", path+".missing"))
+	require.Error(t, err)
+}
+
+func TestLoadNumVersionsToKeep(t *testing.T) {
+	clearEnv(t)
+	cfg, err := config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Equal(t, 1, cfg.MemDB.NumVersionsToKeep)
+
+	setEnv(t, "GETIR_MEMDB_NUM_VERSIONS_TO_KEEP", "10")
+	cfg, err = config.Load("test", []string{"-mongo-uri", "mongodb://x"})
+	require.NoError(t, err)
+	require.Equal(t, 10, cfg.MemDB.NumVersionsToKeep)
+
+	_, err = config.Load("test", []string{"-mongo-uri", "mongodb://x", "-memdb-num-versions-to-keep", "0"})
+	require.Error(t, err)
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration ...
+// time.Duration readable from flags, environment and JSON as a Go duration string like "10s"
+type Duration time.Duration
+
+// String ...
+// Implements flag.Value
+func (d *Duration) String() string { return time.Duration(*d).String() }
+
+// Set ...
+// Implements flag.Value
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON ...
+// Writes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON ...
+// Accepts a Go duration string like "1m30s"
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\"")
+	}
+	return d.Set(s)
+}
@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getircase/db"
+)
+
+// AdminGate ...
+// Administration Gateway/handler for operator requests on the in-memory store
+type AdminGate struct {
+	mgr   db.MemDBManager
+	token string
+}
+
+// NewAdminGate ...
+// Returns the admin handler backed by the given manager
+// an empty token refuses every request, the endpoints are never open
+func NewAdminGate(mgr db.MemDBManager, token string) *AdminGate {
+	return &AdminGate{mgr: mgr, token: token}
+}
+
+// BackupSinceHeader ...
+// Trailer of a backup response holding the since value for the next incremental backup
+const BackupSinceHeader = "X-Backup-Since"
+
+// ServeHTTP ...
+// Generic ServeHttp linked with AdminGate
+// uri path /admin/backup /admin/restore /admin/namespaces and /admin/metrics
+func (gate *AdminGate) ServeHTTP(rw http.ResponseWriter, request *http.Request) {
+	if !gate.authorized(request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		_, _ = rw.Write([]byte("admin token required"))
+		return
+	}
+	switch request.URL.Path {
+	case "/admin/backup":
+		gate.serveBackup(rw, request)
+	case "/admin/restore":
+		gate.serveRestore(rw, request)
+	case "/admin/namespaces":
+		gate.serveNamespaces(rw, request)
+	case "/admin/metrics":
+		gate.serveMetrics(rw, request)
+	default:
+		http.NotFound(rw, request)
+	}
+}
+
+// authorized ...
+// Checks the bearer token, without a configured token nobody is authorized
+func (gate *AdminGate) authorized(request *http.Request) bool {
+	if gate.token == "" {
+		return false
+	}
+	got := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(gate.token)) == 1
+}
+
+// serveBackup ...
+// Serves HTTP method GET
+// uri path value /admin/backup?since=...
+// Streams a badger backup as the response body, since makes it incremental
+func (gate *AdminGate) serveBackup(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		methodNotAllowed(rw, "GET")
+		return
+	}
+	var since uint64
+	if v := request.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte("since must be a version"))
+			return
+		}
+	}
+	// the next since is only known once the stream is written
+	rw.Header().Set("Trailer", BackupSinceHeader)
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"memdb-%s-since-%d.bak\"", time.Now().UTC().Format("20060102T150405Z"), since))
+	// a large store streams for longer than the server write timeout allows
+	clearWriteDeadline(rw)
+	next, err := gate.mgr.Backup(rw, since)
+	if err != nil {
+		// headers are gone, the missing trailer tells the client the backup is incomplete
+		return
+	}
+	rw.Header().Set(BackupSinceHeader, strconv.FormatUint(next, 10))
+}
+
+// serveRestore ...
+// Serves HTTP method POST
+// uri path value /admin/restore
+// Loads a badger backup from the request body into the running store
+func (gate *AdminGate) serveRestore(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		methodNotAllowed(rw, "POST")
+		return
+	}
+	// If body not present throw http.StatusInternalServerError
+	if nil == request.Body {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte("No request content to process"))
+		return
+	}
+	defer request.Body.Close()
+	// a load cut by the server read timeout would leave the store partly overwritten,
+	// and its answer must still be written once a long load is done
+	_ = http.NewResponseController(rw).SetReadDeadline(time.Time{})
+	clearWriteDeadline(rw)
+	if err := gate.mgr.Load(request.Body); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// serveNamespaces ...
+// Serves HTTP method GET and DELETE
+// uri path value /admin/namespaces
+// GET lists the named namespaces holding keys, DELETE ?name=... drops a namespace with all its keys
+func (gate *AdminGate) serveNamespaces(rw http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+		names, err := gate.mgr.Namespaces()
+		if err != nil {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		out, _ := json.Marshal(map[string][]string{"namespaces": names})
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(out)
+	case "DELETE":
+		err := gate.mgr.DropNamespace(request.URL.Query().Get("name"))
+		if err == db.ErrBadNamespace {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(rw, "GET", "DELETE")
+	}
+}
+
+// serveMetrics ...
+// Serves HTTP method GET
+// uri path value /admin/metrics
+// Writes the expvar variables as a JSON object, the command line is left out as it may hold secrets
+func (gate *AdminGate) serveMetrics(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		methodNotAllowed(rw, "GET")
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	first := true
+	fmt.Fprint(rw, "{")
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprint(rw, ",")
+		}
+		first = false
+		fmt.Fprintf(rw, "\n%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(rw, "\n}\n")
+}
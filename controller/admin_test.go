@@ -0,0 +1,195 @@
+package controller_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/getircase/controller"
+	"github.com/getircase/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandlerBackupRestore(t *testing.T) {
+	src := newMemDB(t)
+	require.NoError(t, src.SetKV("active-tabs", "3"))
+	adminServer := controller.NewAdminGate(src, "secret")
+
+	// missing token throw http.StatusUnauthorized
+	req, err := http.NewRequest("GET", "/admin/backup", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	adminServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// backup streams the body and the next since as trailer
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	adminServer.ServeHTTP(rr, req)
+	resp := rr.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+	next, err := strconv.ParseUint(resp.Trailer.Get(controller.BackupSinceHeader), 10, 64)
+	require.NoError(t, err)
+	require.NotZero(t, next)
+	backup := rr.Body.Bytes()
+	require.NotEmpty(t, backup)
+
+	// bad since throw http.StatusBadRequest
+	req, err = http.NewRequest("GET", "/admin/backup?since=x", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	adminServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// restore into another store
+	dst := newMemDB(t)
+	req, err = http.NewRequest("POST", "/admin/restore", bytes.NewReader(backup))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	controller.NewAdminGate(dst, "secret").ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	out, err := dst.Retrieve("active-tabs")
+	require.NoError(t, err)
+	require.Equal(t, "3", out.Value.String())
+
+	// wrong method lists the allowed one
+	req, err = http.NewRequest("GET", "/admin/restore", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	controller.NewAdminGate(dst, "secret").ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	require.Equal(t, "POST", rr.Header().Get("Allow"))
+}
+
+func TestAdminHandlerNamespaces(t *testing.T) {
+	mgr := newMemDB(t)
+	red, err := mgr.Namespace("team-red")
+	require.NoError(t, err)
+	require.NoError(t, red.SetKV("active-tabs", "3"))
+	adminServer := controller.NewAdminGate(mgr, "secret")
+
+	req, err := http.NewRequest("GET", "/admin/namespaces", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	adminServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{"namespaces": ["team-red"]}`, rr.Body.String())
+
+	req, err = http.NewRequest("DELETE", "/admin/namespaces?name=team-red", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	adminServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	found, err := red.Exists("active-tabs")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// missing name throw http.StatusBadRequest
+	req, err = http.NewRequest("DELETE", "/admin/namespaces", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	adminServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAdminHandlerMetrics(t *testing.T) {
+	adminServer := controller.NewAdminGate(newMemDB(t), "secret")
+	req, err := http.NewRequest("GET", "/admin/metrics", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	adminServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var vars map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &vars))
+	require.Contains(t, vars, "memdb")
+	require.Contains(t, vars, "memstats")
+	// the command line may hold credentials
+	require.NotContains(t, vars, "cmdline")
+}
+
+func TestAdminHandlerNoToken(t *testing.T) {
+	// without a configured token every request is refused, even without credentials
+	adminServer := controller.NewAdminGate(newMemDB(t), "")
+	for _, path := range []string{"/admin/backup", "/admin/restore", "/admin/namespaces", "/admin/metrics"} {
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		adminServer.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		req.Header.Set("Authorization", "Bearer ")
+		rr = httptest.NewRecorder()
+		adminServer.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestAdminHandlerServerTimeouts(t *testing.T) {
+	src := newMemDB(t)
+	value := make([]byte, 32<<10)
+	for i := 0; i < 512; i++ {
+		_, err := rand.Read(value)
+		require.NoError(t, err)
+		require.NoError(t, src.SetKV("blob-"+strconv.Itoa(i), hex.EncodeToString(value)))
+	}
+	serve := func(mgr db.MemDBManager) *httptest.Server {
+		server := httptest.NewUnstartedServer(controller.NewAdminGate(mgr, "secret"))
+		server.Config.ReadTimeout = 300 * time.Millisecond
+		server.Config.WriteTimeout = 300 * time.Millisecond
+		server.Start()
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	// a backup read slower than the write timeout allows is complete with its trailer
+	server := serve(src)
+	req, err := http.NewRequest("GET", server.URL+"/admin/backup", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	time.Sleep(time.Second)
+	backup, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.Trailer.Get(controller.BackupSinceHeader))
+
+	// a restore sent slower than the read timeout allows is loaded whole
+	dst := newMemDB(t)
+	server = serve(dst)
+	body, w := io.Pipe()
+	go func() {
+		_, _ = w.Write(backup[:len(backup)/2])
+		time.Sleep(time.Second)
+		_, _ = w.Write(backup[len(backup)/2:])
+		_ = w.Close()
+	}()
+	req, err = http.NewRequest("POST", server.URL+"/admin/restore", body)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, string(msg))
+	out, err := dst.Retrieve("blob-511")
+	require.NoError(t, err)
+	require.Len(t, out.Value.String(), 64<<10)
+}
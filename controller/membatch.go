@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+)
+
+// serveBatch ...
+// Serves HTTP method GET and POST
+// uri path value /in-memory/batch
+// GET ?key=a&key=b reads several keys, POST writes an array of key value pairs atomically
+func (gate *MemDbGate) serveBatch(rw http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+		keys, ok := request.URL.Query()["key"]
+		// No keys requested throw http.StatusForbidden as the single key GET does
+		if !ok {
+			rw.WriteHeader(http.StatusForbidden)
+			_, _ = rw.Write([]byte("key request Parameter not Provided"))
+			return
+		}
+		result, err := gate.mgr.GetBatch(keys)
+		if err != nil {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		out, _ := json.Marshal(result)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(out)
+	case "POST":
+		// If body not present throw http.StatusInternalServerError
+		if nil == request.Body {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte("No request content to process"))
+			return
+		}
+		defer request.Body.Close()
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		var content []models.InMemory
+		if err = json.Unmarshal(body, &content); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		// Nothing is stored when any item fails
+		err = gate.mgr.SetBatch(content)
+		if errors.Is(err, db.ErrTxnTooBig) || errors.Is(err, db.ErrValueTooLarge) {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if errors.Is(err, db.ErrEmptyKey) || errors.Is(err, db.ErrReservedKey) {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if err == db.ErrQuotaExceeded || err == db.ErrStoreFull {
+			rw.WriteHeader(http.StatusInsufficientStorage)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = rw.Write(body)
+	default:
+		methodNotAllowed(rw, "GET", "POST")
+	}
+}
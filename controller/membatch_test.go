@@ -0,0 +1,63 @@
+package controller_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getircase/controller"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemDbHandlerBatch(t *testing.T) {
+	mgr := newMemDB(t)
+	memServer := controller.NewMemDbGate(mgr)
+	// store several keys in one request
+	payload := []byte(`[{"key": "tab-1", "value": "a"}, {"key": "tab-2", "value": "b", "ttl": "1m"}]`)
+	req, err := http.NewRequest("POST", "/in-memory/batch", bytes.NewReader(payload))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	// read them back with one unknown key
+	var out models.InMemoryBatch
+	req, err = http.NewRequest("GET", "/in-memory/batch?key=tab-1&key=tab-2&key=tab-3", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	require.Len(t, out.Records, 2)
+	require.Equal(t, "a", out.Records[0].Value.String())
+	require.Equal(t, "b", out.Records[1].Value.String())
+	require.Equal(t, []string{"tab-3"}, out.Missing)
+
+	// an empty key rejects the whole batch with http.StatusBadRequest
+	payload = []byte(`[{"key": "tab-4", "value": "c"}, {"key": "", "value": "d"}]`)
+	req, err = http.NewRequest("POST", "/in-memory/batch", bytes.NewReader(payload))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	found, err := mgr.Exists("tab-4")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// not an array throw http.StatusBadRequest
+	req, err = http.NewRequest("POST", "/in-memory/batch", bytes.NewReader([]byte(`{"key": "x"}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// no key parameter throw http.StatusForbidden
+	req, err = http.NewRequest("GET", "/in-memory/batch", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+)
+
+// serveCounter ...
+// Serves HTTP method POST
+// uri path value /in-memory/incr and /in-memory/decr
+// Adds (sign 1) or subtracts (sign -1) delta atomically and returns the new value
+func (gate *MemDbGate) serveCounter(rw http.ResponseWriter, request *http.Request, sign int64) {
+	if request.Method != "POST" {
+		methodNotAllowed(rw, "POST")
+		return
+	}
+	// If body not present throw http.StatusInternalServerError
+	if nil == request.Body {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte("No request content to process"))
+		return
+	}
+	defer request.Body.Close()
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	var content models.Counter
+	if err = json.Unmarshal(body, &content); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	delta := int64(1)
+	if content.Delta != nil {
+		delta = *content.Delta
+	}
+	// negating the smallest int64 overflows
+	if sign < 0 && delta == math.MinInt64 {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = rw.Write([]byte(db.ErrOverflow.Error()))
+		return
+	}
+	content.Value, err = gate.mgr.Incr(content.Key, sign*delta)
+	switch err {
+	case nil:
+	case db.ErrNotNumeric, db.ErrOverflow:
+		// existing value can not be used as a counter
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	case db.ErrEmptyKey, db.ErrReservedKey:
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	case db.ErrQuotaExceeded, db.ErrStoreFull:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	default:
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	content.Delta = &delta
+	out, _ := json.Marshal(content)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(out)
+}
@@ -0,0 +1,59 @@
+package controller_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getircase/controller"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemDbHandlerCounter(t *testing.T) {
+	mgr := newMemDB(t)
+	memServer := controller.NewMemDbGate(mgr)
+	var resp models.Counter
+	// missing key starts at 0 and delta defaults to 1
+	req, err := http.NewRequest("POST", "/in-memory/incr", bytes.NewReader([]byte(`{"key": "visits"}`)))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, int64(1), resp.Value)
+
+	req, err = http.NewRequest("POST", "/in-memory/incr", bytes.NewReader([]byte(`{"key": "visits", "delta": 10}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, int64(11), resp.Value)
+
+	req, err = http.NewRequest("POST", "/in-memory/decr", bytes.NewReader([]byte(`{"key": "visits", "delta": 4}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, int64(7), resp.Value)
+
+	// non numeric value throw http.StatusUnprocessableEntity
+	require.NoError(t, mgr.SetKV("label", "blue"))
+	req, err = http.NewRequest("POST", "/in-memory/incr", bytes.NewReader([]byte(`{"key": "label"}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	require.Equal(t, "value is not an integer", rr.Body.String())
+
+	// fractional delta throw http.StatusBadRequest
+	req, err = http.NewRequest("POST", "/in-memory/incr", bytes.NewReader([]byte(`{"key": "visits", "delta": 1.5}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
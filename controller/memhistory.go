@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/getircase/db"
+)
+
+// serveHistory ...
+// Serves HTTP method GET
+// uri path value /in-memory/history?key=...
+// Lists the versions of the key the store still keeps, newest first
+func (gate *MemDbGate) serveHistory(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		methodNotAllowed(rw, "GET")
+		return
+	}
+	key, ok := queryKey(rw, request)
+	if !ok {
+		return
+	}
+	result, err := gate.mgr.History(key)
+	if err == db.ErrEmptyKey || err == db.ErrReservedKey {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	// Unknown key throw http.StatusNotFound
+	if err == db.ErrKeyNotFound {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	out, _ := json.Marshal(result)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(out)
+}
@@ -0,0 +1,59 @@
+package controller_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/getircase/config"
+	"github.com/getircase/controller"
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemDbHandlerHistory(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.NumVersionsToKeep = 5
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+	memServer := controller.NewMemDbGate(mgr)
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		memServer.ServeHTTP(rr, req)
+		return rr
+	}
+	require.Equal(t, http.StatusCreated, serve("POST", "/in-memory", `{"key": "cfg", "value": "good"}`).Code)
+	require.Equal(t, http.StatusCreated, serve("POST", "/in-memory", `{"key": "cfg", "value": "bad"}`).Code)
+
+	// versions newest first with their write time
+	rr := serve("GET", "/in-memory/history?key=cfg", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var history models.KeyHistory
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &history))
+	require.Len(t, history.Versions, 2)
+	require.Equal(t, "bad", history.Versions[0].Value.String())
+	require.Equal(t, "good", history.Versions[1].Value.String())
+	require.NotNil(t, history.Versions[1].Time)
+
+	// asOf reads the key at an earlier version
+	var resp models.InMemory
+	rr = serve("GET", "/in-memory?key=cfg&asOf="+strconv.FormatUint(uint64(history.Versions[1].Version), 10), "")
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, "good", resp.Value.String())
+	require.Equal(t, http.StatusNotFound, serve("GET", "/in-memory?key=cfg&asOf=0", "").Code)
+	require.Equal(t, http.StatusBadRequest, serve("GET", "/in-memory?key=cfg&asOf=yesterday", "").Code)
+
+	// unknown key throw http.StatusNotFound, other methods http.StatusMethodNotAllowed
+	require.Equal(t, http.StatusNotFound, serve("GET", "/in-memory/history?key=missing", "").Code)
+	require.Equal(t, http.StatusForbidden, serve("GET", "/in-memory/history", "").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, serve("POST", "/in-memory/history?key=cfg", "").Code)
+}
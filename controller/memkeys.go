@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/getircase/db"
+)
+
+// Page size bounds of the key listing
+const (
+	defaultKeysLimit = 100
+	maxKeysLimit     = 1000
+)
+
+// serveKeys ...
+// Serves HTTP method GET
+// uri path value /in-memory/keys?prefix=...&limit=...&cursor=...&values=true
+// Lists stored keys under prefix one page at a time
+func (gate *MemDbGate) serveKeys(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		methodNotAllowed(rw, "GET")
+		return
+	}
+	query := request.URL.Query()
+	limit := defaultKeysLimit
+	// limit outside 1..maxKeysLimit throw http.StatusBadRequest
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxKeysLimit {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte("limit must be between 1 and " + strconv.Itoa(maxKeysLimit)))
+			return
+		}
+		limit = n
+	}
+	withValues, _ := strconv.ParseBool(query.Get("values"))
+	result, err := gate.mgr.List(query.Get("prefix"), limit, query.Get("cursor"), withValues)
+	if err == db.ErrBadCursor || err == db.ErrReservedKey {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	out, _ := json.Marshal(result)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(out)
+}
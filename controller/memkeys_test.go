@@ -0,0 +1,58 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/getircase/controller"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemDbHandlerKeys(t *testing.T) {
+	mgr := newMemDB(t)
+	memServer := controller.NewMemDbGate(mgr)
+	for _, k := range []string{"tabs/1", "tabs/2", "tabs/3", "users/1"} {
+		require.NoError(t, mgr.SetKV(k, "v"))
+	}
+	var page models.KeyList
+	// walk the namespace two keys at a time
+	req, err := http.NewRequest("GET", "/in-memory/keys?prefix=tabs/&limit=2", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Equal(t, []string{"tabs/1", "tabs/2"}, page.Keys)
+	require.NotEmpty(t, page.Cursor)
+
+	req, err = http.NewRequest("GET", "/in-memory/keys?prefix=tabs/&limit=2&values=true&cursor="+url.QueryEscape(page.Cursor), nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	page = models.KeyList{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Equal(t, []string{"tabs/3"}, page.Keys)
+	require.Equal(t, "v", page.Values["tabs/3"].String())
+	require.Empty(t, page.Cursor)
+
+	// bad limit and bad cursor throw http.StatusBadRequest
+	for _, q := range []string{"limit=0", "limit=abc", "limit=5000", "cursor=%21%21"} {
+		req, err = http.NewRequest("GET", "/in-memory/keys?"+q, nil)
+		require.NoError(t, err)
+		rr = httptest.NewRecorder()
+		memServer.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code, q)
+	}
+
+	// unknown sub path reads a raw value, a missing key throw http.StatusNotFound
+	req, err = http.NewRequest("GET", "/in-memory/nope", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+)
+
+// serveRaw ...
+// Serves HTTP method GET HEAD and PUT
+// uri path value /in-memory/{key}
+// PUT stores the request body as is with its Content-Type, an optional ttl query parameter expires it
+// GET answers the stored bytes with that content type, strings and JSON values as text and JSON
+func (gate *MemDbGate) serveRaw(rw http.ResponseWriter, request *http.Request, key string) {
+	switch request.Method {
+	case "GET", "HEAD":
+		// Unknown key throw http.StatusNotFound
+		blob, err := gate.mgr.RetrieveBlob(key)
+		if err == db.ErrEmptyKey || err == db.ErrReservedKey {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			rw.WriteHeader(http.StatusNotFound)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		rw.Header().Set("Content-Type", blob.ContentType)
+		rw.Header().Set("Content-Length", strconv.Itoa(len(blob.Data)))
+		rw.Header().Set("ETag", strconv.Quote(strconv.FormatUint(uint64(blob.Version), 10)))
+		rw.WriteHeader(http.StatusOK)
+		if request.Method == "GET" {
+			_, _ = rw.Write(blob.Data)
+		}
+	case "PUT":
+		var ttl models.TTL
+		if v := request.URL.Query().Get("ttl"); v != "" {
+			// seconds or a duration string, the same as the ttl of a JSON payload
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				v = strconv.Quote(v)
+			}
+			if err := json.Unmarshal([]byte(v), &ttl); err != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				_, _ = rw.Write([]byte(err.Error()))
+				return
+			}
+		}
+		// If body not present throw http.StatusInternalServerError
+		if nil == request.Body {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte("No request content to process"))
+			return
+		}
+		defer request.Body.Close()
+		// the body is the value, reading past the max value size is refused before it is buffered
+		data, err := readBody(rw, request, gate.mgr.MaxValueSize())
+		if err != nil {
+			return
+		}
+		err = gate.mgr.SetBlob(key, models.Blob{ContentType: request.Header.Get("Content-Type"), Data: data}, ttl.Duration())
+		switch err {
+		case nil:
+		case db.ErrEmptyKey, db.ErrReservedKey:
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		case db.ErrValueTooLarge:
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		case db.ErrQuotaExceeded, db.ErrStoreFull:
+			rw.WriteHeader(http.StatusInsufficientStorage)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		default:
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+	default:
+		methodNotAllowed(rw, "GET", "HEAD", "PUT")
+	}
+}
+
+// jsonEnvelope ...
+// Bytes a JSON payload may hold beyond the max value size, for the key, the other fields and escaping
+const jsonEnvelope = 64 << 10
+
+// readBody ...
+// Reads the request body, at most limit bytes of it when limit is above 0
+// writes http.StatusRequestEntityTooLarge for a longer body and http.StatusInternalServerError
+// for other read errors, then returns the error
+func readBody(rw http.ResponseWriter, request *http.Request, limit int64) ([]byte, error) {
+	body := request.Body
+	if limit > 0 {
+		body = http.MaxBytesReader(rw, request.Body, limit)
+	}
+	data, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		rw.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = rw.Write([]byte(db.ErrValueTooLarge.Error()))
+		return nil, err
+	}
+	if err != nil {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte(err.Error()))
+		return nil, err
+	}
+	return data, nil
+}
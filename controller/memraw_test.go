@@ -0,0 +1,139 @@
+package controller_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getircase/config"
+	"github.com/getircase/controller"
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemDbHandlerRaw(t *testing.T) {
+	memServer := controller.NewMemDbGate(newMemDB(t))
+	serve := func(method, url, contentType string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		memServer.ServeHTTP(rr, req)
+		return rr
+	}
+	png := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+	require.Equal(t, http.StatusCreated, serve("PUT", "/in-memory/images/logo.png?ttl=1h", "image/png", png).Code)
+
+	// the bytes come back with the original content type
+	rr := serve("GET", "/in-memory/images/logo.png", "", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	require.Equal(t, png, rr.Body.Bytes())
+	require.NotEmpty(t, rr.Header().Get("ETag"))
+	rr = serve("HEAD", "/in-memory/images/logo.png", "", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "8", rr.Header().Get("Content-Length"))
+
+	// the JSON endpoint reports the base64 of the bytes and the ttl
+	var resp models.InMemory
+	rr = serve("GET", "/in-memory?key=images/logo.png", "", nil)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, "image/png", resp.ContentType)
+	require.Equal(t, "iVBORw0KGgo=", resp.Value.String())
+	require.InDelta(t, float64(time.Hour), float64(resp.TTL), float64(2*time.Second))
+
+	// values written as JSON read back as text or JSON
+	require.Equal(t, http.StatusCreated, serve("POST", "/in-memory", "", []byte(`{"key": "cfg", "value": {"a": 1}}`)).Code)
+	rr = serve("GET", "/in-memory/cfg", "", nil)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.Equal(t, `{"a":1}`, rr.Body.String())
+
+	// the fixed sub paths shadow keys of the same name
+	require.Equal(t, http.StatusMethodNotAllowed, serve("PUT", "/in-memory/keys", "text/plain", []byte("x")).Code)
+
+	// unknown key throw http.StatusNotFound, bad ttl http.StatusBadRequest
+	require.Equal(t, http.StatusNotFound, serve("GET", "/in-memory/missing", "", nil).Code)
+	require.Equal(t, http.StatusBadRequest, serve("PUT", "/in-memory/k?ttl=-5", "", nil).Code)
+	require.Equal(t, http.StatusMethodNotAllowed, serve("POST", "/in-memory/k", "", nil).Code)
+}
+
+func TestMemDbHandlerRawTooLarge(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.MaxValueSize = 8
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+	memServer := controller.NewMemDbGate(mgr)
+
+	req, err := http.NewRequest("PUT", "/in-memory/blob", bytes.NewReader(make([]byte, 9)))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	// the same bound applies to JSON values
+	req, err = http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(`{"key": "k", "value": "123456789"}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+// endlessBody ...
+// Request body of zeros that never ends, counting the bytes read from it
+type endlessBody struct {
+	read int64
+}
+
+func (b *endlessBody) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = '0'
+	}
+	b.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestMemDbHandlerBodyLimit(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.MaxValueSize = 1 << 10
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+	memServer := controller.NewMemDbGate(mgr)
+
+	// a raw body is not read much past the max value size
+	body := &endlessBody{}
+	req, err := http.NewRequest("PUT", "/in-memory/blob", body)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	require.Less(t, body.read, int64(64<<10))
+
+	// nor is a JSON body past the max value size and its envelope
+	body = &endlessBody{}
+	req, err = http.NewRequest("POST", "/in-memory", io.MultiReader(strings.NewReader(`{"key": "k", "value": "`), body))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	require.Less(t, body.read, int64(128<<10))
+
+	// a value within the limit is still stored
+	req, err = http.NewRequest("PUT", "/in-memory/blob", bytes.NewReader(make([]byte, 1<<10)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+}
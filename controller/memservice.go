@@ -2,8 +2,12 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/getircase/db"
 	"github.com/getircase/models"
@@ -11,46 +15,147 @@ import (
 
 // MemDbGate ...
 // In-Memory Gateway/handler for in-memory based request
-type MemDbGate struct{}
+// closing ends the long-lived watch streams and WebSockets on shutdown
+type MemDbGate struct {
+	mgr       db.MemDBManager
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemDbGate ...
+// Returns the in-memory handler backed by the given manager
+func NewMemDbGate(mgr db.MemDBManager) *MemDbGate {
+	return &MemDbGate{mgr: mgr, closing: make(chan struct{})}
+}
+
+// CloseWatchers ...
+// Ends every open watch stream and WebSocket so a graceful shutdown does not wait on them
+func (gate *MemDbGate) CloseWatchers() {
+	gate.closeOnce.Do(func() { close(gate.closing) })
+}
+
+// memPath ...
+// uri path the gate is mounted on, sub paths select the operation
+const memPath = "/in-memory"
+
+// NamespaceHeader ...
+// Request header selecting the namespace of the in-memory keys
+// the namespace query parameter does the same for clients that can not set headers
+const NamespaceHeader = "X-Namespace"
 
 // ServeHTTP ...
 // Generic ServeHttp linked with MemDbGate
-// Serves HTTP method GET and POST
-// uri path value /in-memory
+// Dispatches on the uri path below /in-memory, any other path names the key of a raw value
+// so keys named like the fixed sub paths are only reachable with ?key=
+// Requests act on the namespace named by NamespaceHeader or the namespace query parameter
 func (gate *MemDbGate) ServeHTTP(rw http.ResponseWriter, request *http.Request) {
+	name := request.URL.Query().Get("namespace")
+	if name == "" {
+		name = request.Header.Get(NamespaceHeader)
+	}
+	mgr, err := gate.mgr.Namespace(name)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	// same gate scoped to the namespace for this request
+	scoped := &MemDbGate{mgr: mgr, closing: gate.closing}
+	switch strings.TrimPrefix(request.URL.Path, memPath) {
+	case "", "/":
+		scoped.serveKV(rw, request)
+	case "/ttl":
+		scoped.serveTTL(rw, request)
+	case "/keys":
+		scoped.serveKeys(rw, request)
+	case "/history":
+		scoped.serveHistory(rw, request)
+	case "/batch":
+		scoped.serveBatch(rw, request)
+	case "/incr":
+		scoped.serveCounter(rw, request, 1)
+	case "/decr":
+		scoped.serveCounter(rw, request, -1)
+	case "/watch":
+		scoped.serveWatch(rw, request)
+	case "/ws":
+		scoped.serveSocket(rw, request)
+	default:
+		scoped.serveRaw(rw, request, strings.TrimPrefix(request.URL.Path, memPath+"/"))
+	}
+}
+
+// serveKV ...
+// Serves HTTP method GET HEAD POST and DELETE
+// uri path value /in-memory
+// GET accepts an optional JSON pointer path=/a/b reading part of a JSON value
+// and an optional asOf=version reading the key as it was at that version
+func (gate *MemDbGate) serveKV(rw http.ResponseWriter, request *http.Request) {
 	var err error
-	var result interface{}
+	var result models.InMemory
 	var out, body []byte
 
-	if request.Method == "GET" {
-		// Get Key path parameter from url path
-		keys, ok := request.URL.Query()["key"]
-		// No found the required path throw http.StatusForbidden
+	switch request.Method {
+	case "GET":
+		key, ok := queryKey(rw, request)
 		if !ok {
-			rw.WriteHeader(http.StatusForbidden)
-			rw.Write([]byte("key request Parameter not Provided"))
 			return
 		}
-		// Not found or more than required number of parameters in the url path throw http.StatusBadRequest
-		if len(keys) != 1 {
+		// Retrieve associated Value for the requested key, as of a version when asOf is given
+		// A malformed asOf throw http.StatusBadRequest
+		// If there is an error throw http.StatusNotFound
+		if asOf := request.URL.Query().Get("asOf"); asOf != "" {
+			version, perr := strconv.ParseUint(asOf, 10, 64)
+			if perr != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				_, _ = rw.Write([]byte("asOf must be a key version"))
+				return
+			}
+			result, err = gate.mgr.RetrieveAt(key, version)
+		} else {
+			result, err = gate.mgr.Retrieve(key)
+		}
+		if err != nil {
+			rw.WriteHeader(http.StatusNotFound)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		// narrow a JSON value to the requested path
+		// a malformed path throw http.StatusBadRequest, a path not in the value http.StatusNotFound
+		result.Value, err = result.Value.Pointer(request.URL.Query().Get("path"))
+		if err == models.ErrBadPointer {
 			rw.WriteHeader(http.StatusBadRequest)
-			rw.Write([]byte("key request Parameter not Provided"))
+			_, _ = rw.Write([]byte(err.Error()))
 			return
 		}
-		// Retrieve associated Value for the requested key
-		// If there is an error throw http.StatusNotFound
-		result, err = db.MemDBMgr().Retrieve(keys[0])
 		if err != nil {
 			rw.WriteHeader(http.StatusNotFound)
 			_, _ = rw.Write([]byte(err.Error()))
 			return
 		}
 		// return Associated Value for the requested key in the requested format
-		gr := result.(map[string]string)
-		out, _ = json.Marshal(gr)
+		out, _ = json.Marshal(result)
+		// version doubles as entity tag for conditional writes
+		rw.Header().Set("ETag", strconv.Quote(strconv.FormatUint(uint64(result.Version), 10)))
 		rw.WriteHeader(http.StatusAccepted)
 		rw.Write(out)
-	} else if request.Method == "POST" {
+	case "HEAD":
+		key, ok := queryKey(rw, request)
+		if !ok {
+			return
+		}
+		// Existence check only, no body is written
+		found, err := gate.mgr.Exists(key)
+		if err != nil {
+			rw.WriteHeader(500)
+			return
+		}
+		if !found {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	case "POST":
 		// If body not present throw http.StatusInternalServerError
 		if nil == request.Body {
 			rw.WriteHeader(500)
@@ -59,10 +164,12 @@ func (gate *MemDbGate) ServeHTTP(rw http.ResponseWriter, request *http.Request)
 		}
 		defer request.Body.Close()
 		// If body can not be read throw http.StatusInternalServerError
-		body, err = ioutil.ReadAll(request.Body)
-		if err != nil {
-			rw.WriteHeader(500)
-			_, _ = rw.Write([]byte(err.Error()))
+		// a body that can not hold a value within the max value size throw http.StatusRequestEntityTooLarge
+		limit := gate.mgr.MaxValueSize()
+		if limit > 0 {
+			limit += jsonEnvelope
+		}
+		if body, err = readBody(rw, request, limit); err != nil {
 			return
 		}
 		var content models.InMemory
@@ -73,9 +180,49 @@ func (gate *MemDbGate) ServeHTTP(rw http.ResponseWriter, request *http.Request)
 			_, _ = rw.Write([]byte(err.Error()))
 			return
 		}
-		// Set the associated value for the given key
+		// Conditional write when If-Match or expectedVersion is given
+		// a changed key throw http.StatusPreconditionFailed or http.StatusConflict respectively
+		if match := request.Header.Get("If-Match"); match != "" {
+			expected, perr := parseETag(match)
+			if perr != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				_, _ = rw.Write([]byte(perr.Error()))
+				return
+			}
+			err = gate.mgr.CompareAndSet(content.Key, content.Value, content.TTL.Duration(), expected)
+			if err == db.ErrVersionMismatch {
+				rw.WriteHeader(http.StatusPreconditionFailed)
+				_, _ = rw.Write([]byte(err.Error()))
+				return
+			}
+		} else if content.ExpectedVersion != nil {
+			err = gate.mgr.CompareAndSet(content.Key, content.Value, content.TTL.Duration(), uint64(*content.ExpectedVersion))
+			if err == db.ErrVersionMismatch {
+				rw.WriteHeader(http.StatusConflict)
+				_, _ = rw.Write([]byte(err.Error()))
+				return
+			}
+		} else {
+			// Set the associated value for the given key
+			err = gate.mgr.Set(content.Key, content.Value, content.TTL.Duration())
+		}
+		// a full namespace or store throw http.StatusInsufficientStorage
+		if err == db.ErrQuotaExceeded || err == db.ErrStoreFull {
+			rw.WriteHeader(http.StatusInsufficientStorage)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if err == db.ErrReservedKey {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if err == db.ErrValueTooLarge {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
 		// if err is present throw http.StatusInternalServerError
-		err = db.MemDBMgr().SetKV(content.Key, content.Value)
 		if err != nil {
 			rw.WriteHeader(500)
 			_, _ = rw.Write([]byte(err.Error()))
@@ -85,5 +232,115 @@ func (gate *MemDbGate) ServeHTTP(rw http.ResponseWriter, request *http.Request)
 		// write response for the request
 		rw.WriteHeader(http.StatusCreated)
 		rw.Write(body)
+	case "DELETE":
+		key, ok := queryKey(rw, request)
+		if !ok {
+			return
+		}
+		// Unknown key throw http.StatusNotFound
+		err = gate.mgr.Delete(key)
+		if err == db.ErrKeyNotFound {
+			rw.WriteHeader(http.StatusNotFound)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if err == db.ErrEmptyKey || err == db.ErrReservedKey {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			rw.WriteHeader(500)
+			_, _ = rw.Write([]byte(err.Error()))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(rw, "GET", "HEAD", "POST", "DELETE")
+	}
+}
+
+// queryKey ...
+// Reads the single key request parameter from the url
+// writes the error response and return false when it is missing
+func queryKey(rw http.ResponseWriter, request *http.Request) (string, bool) {
+	// Get Key path parameter from url path
+	keys, ok := request.URL.Query()["key"]
+	// No found the required path throw http.StatusForbidden
+	if !ok {
+		rw.WriteHeader(http.StatusForbidden)
+		rw.Write([]byte("key request Parameter not Provided"))
+		return "", false
+	}
+	// Not found or more than required number of parameters in the url path throw http.StatusBadRequest
+	if len(keys) != 1 {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("key request Parameter not Provided"))
+		return "", false
+	}
+	return keys[0], true
+}
+
+// parseETag ...
+// Reads the key version from an If-Match header value like "12"
+func parseETag(tag string) (uint64, error) {
+	tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+	version, err := strconv.ParseUint(tag, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("If-Match must hold a key version")
+	}
+	return version, nil
+}
+
+// methodNotAllowed ...
+// Writes http.StatusMethodNotAllowed listing the supported methods in the Allow header
+func methodNotAllowed(rw http.ResponseWriter, allow ...string) {
+	rw.Header().Set("Allow", strings.Join(allow, ", "))
+	rw.WriteHeader(http.StatusMethodNotAllowed)
+	_, _ = rw.Write([]byte("http Method not supported"))
+}
+
+// serveTTL ...
+// Serves HTTP method POST
+// uri path value /in-memory/ttl
+// Refreshes the time to live of an existing key, zero ttl removes the expiry
+func (gate *MemDbGate) serveTTL(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		methodNotAllowed(rw, "POST")
+		return
+	}
+	// If body not present throw http.StatusInternalServerError
+	if nil == request.Body {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte("No request content to process"))
+		return
+	}
+	defer request.Body.Close()
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	var content models.InMemory
+	if err = json.Unmarshal(body, &content); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	err = gate.mgr.Expire(content.Key, content.TTL.Duration())
+	if err == db.ErrQuotaExceeded || err == db.ErrStoreFull {
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	// Unknown key throw http.StatusNotFound
+	if err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
 	}
+	out, _ := json.Marshal(map[string]interface{}{"key": content.Key, "ttl": content.TTL})
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(out)
 }
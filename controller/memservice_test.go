@@ -3,18 +3,23 @@ package controller_test
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 
 	"testing"
+	"time"
 
+	"github.com/getircase/config"
 	"github.com/getircase/controller"
+	"github.com/getircase/db"
 	"github.com/getircase/models"
 	"github.com/stretchr/testify/require"
 )
 
 func TestMemDbHandlerGetError(t *testing.T) {
+	mgr := newMemDB(t)
 	var memServer http.Handler
 	var req *http.Request
 	var err error
@@ -27,7 +32,7 @@ func TestMemDbHandlerGetError(t *testing.T) {
 	require.NoError(t, err)
 	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 	rr := httptest.NewRecorder()
-	memServer = new(controller.MemDbGate)
+	memServer = controller.NewMemDbGate(mgr)
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
 	// directly and pass in our Request and ResponseRecorder.
 	memServer.ServeHTTP(rr, req)
@@ -56,6 +61,7 @@ func TestMemDbHandlerGetError(t *testing.T) {
 }
 
 func TestMemDbHandlerPostError(t *testing.T) {
+	mgr := newMemDB(t)
 
 	var memServer http.Handler
 	var req *http.Request
@@ -63,7 +69,7 @@ func TestMemDbHandlerPostError(t *testing.T) {
 	var body []byte
 	rq := map[string]string{}
 	// Body wiith Empty key
-	memServer = new(controller.MemDbGate)
+	memServer = controller.NewMemDbGate(mgr)
 	// Generate Body object to pass to request
 	rq["key"] = ""
 	rq["value"] = "testValue"
@@ -102,6 +108,7 @@ func TestMemDbHandlerPostError(t *testing.T) {
 }
 
 func TestMemDbHandlerPostModelError(t *testing.T) {
+	mgr := newMemDB(t)
 	var memServer http.Handler
 	var req *http.Request
 	var err error
@@ -109,7 +116,7 @@ func TestMemDbHandlerPostModelError(t *testing.T) {
 	rq := map[interface{}]interface{}{}
 	// Bad Request Body Case
 	rq["abc"] = 1.1
-	memServer = new(controller.MemDbGate)
+	memServer = controller.NewMemDbGate(mgr)
 	payloadBytes, _ := json.Marshal(rq)
 	reader := bytes.NewReader(payloadBytes)
 	// Create a Http.POST request to pass to our handler.
@@ -127,7 +134,18 @@ func TestMemDbHandlerPostModelError(t *testing.T) {
 	require.Equal(t, body, []byte("unexpected end of JSON input"))
 }
 
+func TestMemDbHandlerPostTruncatedBody(t *testing.T) {
+	// a truncated JSON document throw http.StatusInternalServerError
+	req, err := http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(`{"key": "abc", "value": "1"`)))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	controller.NewMemDbGate(newMemDB(t)).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	require.Equal(t, "unexpected end of JSON input", rr.Body.String())
+}
+
 func TestMemDbHandlerPostSuccess(t *testing.T) {
+	mgr := newMemDB(t)
 	var memServer http.Handler
 	var req *http.Request
 	var err error
@@ -135,7 +153,7 @@ func TestMemDbHandlerPostSuccess(t *testing.T) {
 	var resp models.InMemory
 	rq := map[string]string{}
 	// Store data in in-memdb
-	memServer = new(controller.MemDbGate)
+	memServer = controller.NewMemDbGate(mgr)
 	rq["key"] = "test"
 	rq["value"] = "testValue"
 	payloadBytes, _ := json.Marshal(rq)
@@ -156,10 +174,11 @@ func TestMemDbHandlerPostSuccess(t *testing.T) {
 	// Check the Response Body and it is what we expect.
 	require.NoError(t, err)
 	require.Equal(t, resp.Key, rq["key"])
-	require.Equal(t, resp.Value, rq["value"])
+	require.Equal(t, resp.Value.String(), rq["value"])
 }
 
 func TestMemDbHandlerGetSuccess(t *testing.T) {
+	mgr := newMemDB(t)
 	var memServer http.Handler
 	var req *http.Request
 	var err error
@@ -167,7 +186,7 @@ func TestMemDbHandlerGetSuccess(t *testing.T) {
 	var resp models.InMemory
 	rq := map[string]string{}
 	// Store the data first and fetch
-	memServer = new(controller.MemDbGate)
+	memServer = controller.NewMemDbGate(mgr)
 	rq["key"] = "test"
 	rq["value"] = "testValue"
 	payloadBytes, _ := json.Marshal(rq)
@@ -182,14 +201,14 @@ func TestMemDbHandlerGetSuccess(t *testing.T) {
 	err = json.Unmarshal(body, &resp)
 	require.NoError(t, err)
 	require.Equal(t, resp.Key, rq["key"])
-	require.Equal(t, resp.Value, rq["value"])
+	require.Equal(t, resp.Value.String(), rq["value"])
 	// Successful get for the above stored key
 	// Create another request with no request body but add the path parameter for 'key' for the stored key
 	req, err = http.NewRequest("GET", "/in-memory?key=test", nil)
 	require.NoError(t, err)
 	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 	rr = httptest.NewRecorder()
-	memServer = new(controller.MemDbGate)
+	memServer = controller.NewMemDbGate(mgr)
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
 	// directly and pass in our Request and ResponseRecorder.
 	memServer.ServeHTTP(rr, req)
@@ -201,6 +220,274 @@ func TestMemDbHandlerGetSuccess(t *testing.T) {
 	err = json.Unmarshal(body, &resp)
 	require.NoError(t, err)
 	require.Equal(t, resp.Key, rq["key"])
-	require.Equal(t, resp.Value, rq["value"])
+	require.Equal(t, resp.Value.String(), rq["value"])
+
+}
+
+func TestMemDbHandlerTTL(t *testing.T) {
+	mgr := newMemDB(t)
+	memServer := controller.NewMemDbGate(mgr)
+	var resp models.InMemory
+	// store a key with ttl given in seconds
+	req, err := http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(`{"key": "active-tabs", "value": "3", "ttl": 60}`)))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	// remaining ttl is reported on GET
+	req, err = http.NewRequest("GET", "/in-memory?key=active-tabs", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.InDelta(t, float64(time.Minute), float64(resp.TTL), float64(2*time.Second))
+
+	// extend with a duration string
+	req, err = http.NewRequest("POST", "/in-memory/ttl", bytes.NewReader([]byte(`{"key": "active-tabs", "ttl": "1h"}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	req, err = http.NewRequest("GET", "/in-memory?key=active-tabs", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.InDelta(t, float64(time.Hour), float64(resp.TTL), float64(2*time.Second))
+	require.Equal(t, "3", resp.Value.String())
+
+	// unknown key throw http.StatusNotFound
+	req, err = http.NewRequest("POST", "/in-memory/ttl", bytes.NewReader([]byte(`{"key": "missing", "ttl": 10}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	// bad ttl throw http.StatusBadRequest
+	req, err = http.NewRequest("POST", "/in-memory/ttl", bytes.NewReader([]byte(`{"key": "active-tabs", "ttl": "-1s"}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestMemDbHandlerJSONValue(t *testing.T) {
+	memServer := controller.NewMemDbGate(newMemDB(t))
+	req, err := http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(`{"key": "cfg", "value": {"feature": {"enabled": true}, "regions": ["eu", "us"]}}`)))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		memServer.ServeHTTP(rr, req)
+		return rr
+	}
+	// the document is written back unescaped
+	rr = get("/in-memory?key=cfg")
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	var out struct {
+		Value map[string]interface{} `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	require.Equal(t, map[string]interface{}{"enabled": true}, out.Value["feature"])
+
+	// path selects a part of the document
+	var resp models.InMemory
+	rr = get("/in-memory?key=cfg&path=/feature/enabled")
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, "true", string(resp.Value))
+	rr = get("/in-memory?key=cfg&path=/regions/1")
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, "us", resp.Value.String())
+	// missing path throw http.StatusNotFound, malformed path http.StatusBadRequest
+	require.Equal(t, http.StatusNotFound, get("/in-memory?key=cfg&path=/feature/missing").Code)
+	require.Equal(t, http.StatusNotFound, get("/in-memory?key=cfg&path=/regions/01").Code)
+	require.Equal(t, http.StatusBadRequest, get("/in-memory?key=cfg&path=feature").Code)
+}
+
+func TestMemDbHandlerDeleteHead(t *testing.T) {
+	mgr := newMemDB(t)
+	memServer := controller.NewMemDbGate(mgr)
+	require.NoError(t, mgr.SetKV("active-tabs", "3"))
+	// HEAD on a stored key answers http.StatusOK without a body
+	req, err := http.NewRequest("HEAD", "/in-memory?key=active-tabs", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, 0, rr.Body.Len())
+	// DELETE answers http.StatusNoContent
+	req, err = http.NewRequest("DELETE", "/in-memory?key=active-tabs", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	// HEAD and DELETE on a missing key answer http.StatusNotFound
+	req, err = http.NewRequest("HEAD", "/in-memory?key=active-tabs", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Equal(t, 0, rr.Body.Len())
+	req, err = http.NewRequest("DELETE", "/in-memory?key=active-tabs", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	// DELETE without key parameter throw http.StatusForbidden
+	req, err = http.NewRequest("DELETE", "/in-memory", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestMemDbHandlerMethodNotAllowed(t *testing.T) {
+	mgr := newMemDB(t)
+	memServer := controller.NewMemDbGate(mgr)
+	req, err := http.NewRequest("PATCH", "/in-memory?key=active-tabs", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	require.Equal(t, "GET, HEAD, POST, DELETE", rr.Header().Get("Allow"))
+
+	req, err = http.NewRequest("GET", "/in-memory/ttl", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	require.Equal(t, "POST", rr.Header().Get("Allow"))
+}
+
+func TestMemDbHandlerConditionalWrite(t *testing.T) {
+	mgr := newMemDB(t)
+	memServer := controller.NewMemDbGate(mgr)
+	require.NoError(t, mgr.SetKV("cfg", "v1"))
+	// GET reports the version in the body and the ETag
+	var resp models.InMemory
+	req, err := http.NewRequest("GET", "/in-memory?key=cfg", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.NotZero(t, resp.Version)
+	etag := rr.Header().Get("ETag")
+	require.Equal(t, fmt.Sprintf("%q", fmt.Sprint(uint64(resp.Version))), etag)
+
+	// If-Match with the current version writes
+	req, err = http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(`{"key": "cfg", "value": "v2"}`)))
+	require.NoError(t, err)
+	req.Header.Set("If-Match", etag)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	// the same stale tag throw http.StatusPreconditionFailed
+	req, err = http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(`{"key": "cfg", "value": "v3"}`)))
+	require.NoError(t, err)
+	req.Header.Set("If-Match", etag)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	// stale expectedVersion throw http.StatusConflict
+	payload := fmt.Sprintf(`{"key": "cfg", "value": "v3", "expectedVersion": %d}`, uint64(resp.Version))
+	req, err = http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(payload)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusConflict, rr.Code)
+	// expectedVersion 0 creates a new key
+	req, err = http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(`{"key": "fresh", "value": "v", "expectedVersion": "0"}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	// malformed If-Match throw http.StatusBadRequest
+	req, err = http.NewRequest("POST", "/in-memory", bytes.NewReader([]byte(`{"key": "cfg", "value": "v4"}`)))
+	require.NoError(t, err)
+	req.Header.Set("If-Match", "*")
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	out, err := mgr.Retrieve("cfg")
+	require.NoError(t, err)
+	require.Equal(t, "v2", out.Value.String())
+}
+
+func TestMemDbHandlerNamespace(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.NamespaceQuota = config.Quota{MaxKeys: 1}
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+	memServer := controller.NewMemDbGate(mgr)
+
+	post := func(url, namespace, body string) int {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		if namespace != "" {
+			req.Header.Set(controller.NamespaceHeader, namespace)
+		}
+		rr := httptest.NewRecorder()
+		memServer.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	require.Equal(t, http.StatusCreated, post("/in-memory", "team-red", `{"key": "active-tabs", "value": "red"}`))
+	require.Equal(t, http.StatusCreated, post("/in-memory", "", `{"key": "active-tabs", "value": "default"}`))
+	// the quota of named namespaces throw http.StatusInsufficientStorage
+	require.Equal(t, http.StatusInsufficientStorage, post("/in-memory", "team-red", `{"key": "other", "value": "x"}`))
+	require.Equal(t, http.StatusInsufficientStorage, post("/in-memory/incr", "team-red", `{"key": "hits"}`))
+	// invalid names throw http.StatusBadRequest
+	require.Equal(t, http.StatusBadRequest, post("/in-memory", "team red", `{"key": "k", "value": "v"}`))
+
+	// the query parameter selects the namespace as well
+	req, err := http.NewRequest("GET", "/in-memory?key=active-tabs&namespace=team-red", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	var out map[string]string
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	require.Equal(t, "red", out["value"])
+
+	req, err = http.NewRequest("GET", "/in-memory?key=active-tabs", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	memServer.ServeHTTP(rr, req)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	require.Equal(t, "default", out["value"])
+
+	// the default namespace can not address named ones
+	require.Equal(t, http.StatusBadRequest, post("/in-memory", "", `{"key": "\u0000team-red\u0000x", "value": "v"}`))
+}
+
+func TestMemDbHandlerStoreFull(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.MaxKeys = 1
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+	memServer := controller.NewMemDbGate(mgr)
 
+	codes := []int{}
+	for _, key := range []string{"a", "b"} {
+		req, err := http.NewRequest("POST", "/in-memory", bytes.NewBufferString(`{"key": "`+key+`", "value": "v"}`))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		memServer.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+	// eviction is disabled by default, the full store throw http.StatusInsufficientStorage
+	require.Equal(t, []int{http.StatusCreated, http.StatusInsufficientStorage}, codes)
 }
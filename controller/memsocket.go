@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection settings
+const (
+	// socketBuffer ...
+	// Responses and events queued per connection before the client counts as too slow
+	socketBuffer = 256
+	// socketMaxWatches ...
+	// Subscriptions a single connection may hold at once
+	socketMaxWatches = 16
+	// socketReadLimit ...
+	// Largest command frame accepted from a client
+	socketReadLimit = 1 << 20
+	// socketWriteWait ...
+	// Time allowed to write a frame to the client
+	socketWriteWait = 10 * time.Second
+	// socketPongWait ...
+	// Time allowed between pongs before the connection counts as dead
+	socketPongWait = 60 * time.Second
+	// socketPingPeriod ...
+	// Interval of pings, shorter than socketPongWait
+	socketPingPeriod = socketPongWait * 9 / 10
+)
+
+// upgrader ...
+// Upgrades /in-memory/ws requests, cross origin browsers are refused
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// socketConn ...
+// State of a single WebSocket client
+// send is drained by the writer, watches maps subscription ids to their cancel func
+type socketConn struct {
+	gate      *MemDbGate
+	ws        *websocket.Conn
+	send      chan models.SocketResponse
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	mu        sync.Mutex
+	watches   map[string]context.CancelFunc
+}
+
+// serveSocket ...
+// Serves HTTP method GET upgraded to WebSocket
+// uri path value /in-memory/ws
+// Every text frame is a JSON models.SocketRequest answered by a models.SocketResponse
+// watch subscriptions push models.SocketResponse frames with op "event"
+func (gate *MemDbGate) serveSocket(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		methodNotAllowed(rw, "GET")
+		return
+	}
+	// Upgrade writes the http error response itself
+	ws, err := upgrader.Upgrade(rw, request, nil)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &socketConn{
+		gate:    gate,
+		ws:      ws,
+		send:    make(chan models.SocketResponse, socketBuffer),
+		ctx:     ctx,
+		cancel:  cancel,
+		watches: map[string]context.CancelFunc{},
+	}
+	go conn.writeLoop()
+	conn.readLoop()
+}
+
+// readLoop ...
+// Reads and executes commands till the client leaves or the connection fails
+func (c *socketConn) readLoop() {
+	defer c.close(websocket.CloseNormalClosure, "")
+	c.ws.SetReadLimit(socketReadLimit)
+	_ = c.ws.SetReadDeadline(time.Now().Add(socketPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+	for {
+		_, frame, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		// a frame that is not a command is answered, the connection stays usable
+		var cmd models.SocketRequest
+		if err = json.Unmarshal(frame, &cmd); err != nil {
+			c.reply(failMsg(models.SocketResponse{}, http.StatusBadRequest, err.Error()))
+			continue
+		}
+		c.reply(c.execute(cmd))
+	}
+}
+
+// writeLoop ...
+// Only writer of the connection, sends queued responses and keepalive pings
+func (c *socketConn) writeLoop() {
+	ping := time.NewTicker(socketPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case resp := <-c.send:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := c.ws.WriteJSON(resp); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.gate.closing:
+			c.close(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// reply ...
+// Queues resp without blocking, a client that does not keep up is disconnected
+func (c *socketConn) reply(resp models.SocketResponse) {
+	select {
+	case c.send <- resp:
+	case <-c.ctx.Done():
+	default:
+		c.close(websocket.ClosePolicyViolation, "send buffer overflow")
+	}
+}
+
+// close ...
+// Ends every subscription and the connection once, telling the client why
+func (c *socketConn) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		if code != websocket.CloseAbnormalClosure {
+			msg := websocket.FormatCloseMessage(code, reason)
+			_ = c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(socketWriteWait))
+		}
+		_ = c.ws.Close()
+	})
+}
+
+// execute ...
+// Runs a single command against the store and returns its response
+func (c *socketConn) execute(cmd models.SocketRequest) models.SocketResponse {
+	resp := models.SocketResponse{ID: cmd.ID, Op: cmd.Op, Code: http.StatusOK}
+	mgr := c.gate.mgr
+	switch cmd.Op {
+	case models.SocketGet:
+		out, err := mgr.Retrieve(cmd.Key)
+		if err != nil {
+			return fail(resp, http.StatusNotFound, err)
+		}
+		if out.Value, err = out.Value.Pointer(cmd.Path); err == models.ErrBadPointer {
+			return fail(resp, http.StatusBadRequest, err)
+		}
+		if err != nil {
+			return fail(resp, http.StatusNotFound, err)
+		}
+		resp.Record = &out
+	case models.SocketSet:
+		if err := mgr.Set(cmd.Key, cmd.Value, cmd.TTL.Duration()); err != nil {
+			return fail(resp, statusOf(err), err)
+		}
+		resp.Code = http.StatusCreated
+	case models.SocketDelete:
+		if err := mgr.Delete(cmd.Key); err != nil {
+			return fail(resp, statusOf(err), err)
+		}
+		resp.Code = http.StatusNoContent
+	case models.SocketWatch:
+		return c.watch(resp, cmd)
+	case models.SocketUnwatch:
+		c.mu.Lock()
+		cancel, ok := c.watches[cmd.ID]
+		delete(c.watches, cmd.ID)
+		c.mu.Unlock()
+		if !ok {
+			return failMsg(resp, http.StatusNotFound, "no watch with this id")
+		}
+		cancel()
+	default:
+		return failMsg(resp, http.StatusBadRequest, "op must be get, set, delete, watch or unwatch")
+	}
+	return resp
+}
+
+// watch ...
+// Starts a subscription named by the command id forwarding events to the client
+// A lagging subscription is ended with an error response, the connection stays open
+func (c *socketConn) watch(resp models.SocketResponse, cmd models.SocketRequest) models.SocketResponse {
+	if cmd.ID == "" {
+		return failMsg(resp, http.StatusBadRequest, "watch needs an id")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.watches[cmd.ID]; ok {
+		return failMsg(resp, http.StatusConflict, "watch id already in use")
+	}
+	if len(c.watches) >= socketMaxWatches {
+		return failMsg(resp, http.StatusTooManyRequests, "too many watches on this connection")
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	watcher, err := c.gate.mgr.Watch(ctx, cmd.Prefix, 0)
+	if err != nil {
+		// the id is never registered, the client may use it again
+		cancel()
+		return fail(resp, statusOf(err), err)
+	}
+	c.watches[cmd.ID] = cancel
+	go func() {
+		for ev := range watcher.Events() {
+			ev := ev
+			c.reply(models.SocketResponse{ID: cmd.ID, Op: models.SocketEvent, Code: http.StatusOK, Event: &ev})
+		}
+		if watcher.Err() == db.ErrWatchOverflow {
+			c.mu.Lock()
+			delete(c.watches, cmd.ID)
+			c.mu.Unlock()
+			c.reply(failMsg(models.SocketResponse{ID: cmd.ID, Op: models.SocketEvent}, http.StatusGone, watcher.Err().Error()))
+		}
+		cancel()
+	}()
+	return resp
+}
+
+// fail ...
+// Fills resp with an error outcome
+func fail(resp models.SocketResponse, code int, err error) models.SocketResponse {
+	return failMsg(resp, code, err.Error())
+}
+
+// failMsg ...
+// Fills resp with an error outcome described by msg
+func failMsg(resp models.SocketResponse, code int, msg string) models.SocketResponse {
+	resp.Code = code
+	resp.Error = msg
+	return resp
+}
+
+// statusOf ...
+// Maps store errors of single key writes to the status the REST endpoint answers
+func statusOf(err error) int {
+	switch err {
+	case db.ErrKeyNotFound:
+		return http.StatusNotFound
+	case db.ErrEmptyKey, db.ErrReservedKey:
+		return http.StatusBadRequest
+	case db.ErrQuotaExceeded, db.ErrStoreFull:
+		return http.StatusInsufficientStorage
+	case db.ErrValueTooLarge:
+		return http.StatusRequestEntityTooLarge
+	}
+	return 500
+}
@@ -0,0 +1,137 @@
+package controller_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getircase/controller"
+	"github.com/getircase/models"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// dialSocket ...
+// Opens a WebSocket to the in-memory gate of server, closed when the test ends
+func dialSocket(t *testing.T, server *httptest.Server) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/in-memory/ws"
+	ws, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	t.Cleanup(func() { _ = ws.Close() })
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(5*time.Second)))
+	return ws
+}
+
+// call ...
+// Sends cmd and returns the next response that is not a watch event
+func call(t *testing.T, ws *websocket.Conn, cmd models.SocketRequest) models.SocketResponse {
+	require.NoError(t, ws.WriteJSON(cmd))
+	for {
+		var resp models.SocketResponse
+		require.NoError(t, ws.ReadJSON(&resp))
+		if resp.Op != models.SocketEvent {
+			return resp
+		}
+	}
+}
+
+func TestMemDbHandlerSocket(t *testing.T) {
+	server := httptest.NewServer(controller.NewMemDbGate(newMemDB(t)))
+	defer server.Close()
+	ws := dialSocket(t, server)
+
+	resp := call(t, ws, models.SocketRequest{ID: "1", Op: "set", Key: "tabs", Value: models.Text("3"), TTL: models.TTL(time.Minute)})
+	require.Equal(t, "1", resp.ID)
+	require.Equal(t, http.StatusCreated, resp.Code)
+
+	resp = call(t, ws, models.SocketRequest{ID: "2", Op: "get", Key: "tabs"})
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "3", resp.Record.Value.String())
+	require.NotZero(t, resp.Record.TTL)
+
+	// JSON documents are kept as they are and path reads a part of them
+	resp = call(t, ws, models.SocketRequest{ID: "2a", Op: "set", Key: "cfg", Value: models.Value(`{"feature":{"enabled":true}}`)})
+	require.Equal(t, http.StatusCreated, resp.Code)
+	resp = call(t, ws, models.SocketRequest{ID: "2b", Op: "get", Key: "cfg", Path: "/feature/enabled"})
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "true", string(resp.Record.Value))
+	resp = call(t, ws, models.SocketRequest{ID: "2c", Op: "get", Key: "cfg", Path: "/feature/missing"})
+	require.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = call(t, ws, models.SocketRequest{ID: "3", Op: "delete", Key: "tabs"})
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	resp = call(t, ws, models.SocketRequest{ID: "4", Op: "get", Key: "tabs"})
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	require.NotEmpty(t, resp.Error)
+
+	// bad commands are answered and the connection stays open
+	resp = call(t, ws, models.SocketRequest{ID: "5", Op: "rename"})
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte("{nope")))
+	var bad models.SocketResponse
+	require.NoError(t, ws.ReadJSON(&bad))
+	require.Equal(t, http.StatusBadRequest, bad.Code)
+	resp = call(t, ws, models.SocketRequest{ID: "6", Op: "delete", Key: "tabs"})
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestMemDbHandlerSocketWatch(t *testing.T) {
+	mgr := newMemDB(t)
+	server := httptest.NewServer(controller.NewMemDbGate(mgr))
+	defer server.Close()
+	ws := dialSocket(t, server)
+
+	resp := call(t, ws, models.SocketRequest{Op: "watch", Prefix: "tabs/"})
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = call(t, ws, models.SocketRequest{ID: "w", Op: "watch", Prefix: "tabs/"})
+	require.Equal(t, http.StatusOK, resp.Code)
+	resp = call(t, ws, models.SocketRequest{ID: "w", Op: "watch", Prefix: "other/"})
+	require.Equal(t, http.StatusConflict, resp.Code)
+	// a refused watch is answered and leaves its id free
+	resp = call(t, ws, models.SocketRequest{ID: "r", Op: "watch", Prefix: "\x00team-red"})
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.NotEmpty(t, resp.Error)
+	resp = call(t, ws, models.SocketRequest{ID: "r", Op: "watch", Prefix: "other/"})
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// keep writing until the subscription is live and the event arrives
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				_ = mgr.SetKV("tabs/1", "open")
+			}
+		}
+	}()
+	var ev models.SocketResponse
+	require.NoError(t, ws.ReadJSON(&ev))
+	require.Equal(t, models.SocketEvent, ev.Op)
+	require.Equal(t, "w", ev.ID)
+	require.NotNil(t, ev.Event)
+	require.Equal(t, models.EventSet, ev.Event.Type)
+	require.Equal(t, "tabs/1", ev.Event.Key)
+	require.Equal(t, "open", ev.Event.Value.String())
+
+	resp = call(t, ws, models.SocketRequest{ID: "w", Op: "unwatch"})
+	require.Equal(t, http.StatusOK, resp.Code)
+	resp = call(t, ws, models.SocketRequest{ID: "w", Op: "unwatch"})
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestMemDbHandlerSocketShutdown(t *testing.T) {
+	gate := controller.NewMemDbGate(newMemDB(t))
+	server := httptest.NewServer(gate)
+	defer server.Close()
+	ws := dialSocket(t, server)
+
+	gate.CloseWatchers()
+	_, _, err := ws.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getircase/db"
+)
+
+// Server-Sent Events stream settings
+const (
+	// watchHeartbeat ...
+	// Interval of comment lines keeping idle streams and proxies alive
+	watchHeartbeat = 15 * time.Second
+	// watchRetry ...
+	// Reconnect delay advertised to EventSource clients
+	watchRetry = 2 * time.Second
+)
+
+// serveWatch ...
+// Serves HTTP method GET
+// uri path value /in-memory/watch?prefix=...
+// Pushes set and delete events of keys under prefix as Server-Sent Events
+// The event id is the key version, the data the JSON encoded models.KVEvent
+// A Last-Event-ID header, sent by reconnecting EventSource clients, or the lastEventId query parameter
+// first replays the keys changed after that version, 410 when too many changed to replay
+// The stream is exempt from the server write timeout, it ends with the client or on shutdown
+func (gate *MemDbGate) serveWatch(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		methodNotAllowed(rw, "GET")
+		return
+	}
+	var since uint64
+	last := request.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = request.URL.Query().Get("lastEventId")
+	}
+	if last != "" {
+		var err error
+		if since, err = strconv.ParseUint(last, 10, 64); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte("Last-Event-ID must be a key version"))
+			return
+		}
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte("streaming not supported"))
+		return
+	}
+	// the watch ends with the request, when the client disconnects
+	// it is registered once Watch returns, so no write after the response starts is missed
+	watcher, err := gate.mgr.Watch(request.Context(), request.URL.Query().Get("prefix"), since)
+	// a failed watch is answered before the stream starts, EventSource does not reconnect after a non-200
+	switch {
+	case err == db.ErrReservedKey:
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	case err == db.ErrWatchOverflow:
+		rw.WriteHeader(http.StatusGone)
+		_, _ = rw.Write([]byte("too many changes since Last-Event-ID, reload and watch without it"))
+		return
+	case err != nil:
+		rw.WriteHeader(500)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+	clearWriteDeadline(rw)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintf(rw, "retry: %d\n\n", watchRetry.Milliseconds())
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-watcher.Events():
+			if !ok {
+				// a lagging client is told to resubscribe, other endings are silent
+				if watcher.Err() == db.ErrWatchOverflow {
+					fmt.Fprintf(rw, "event: error\ndata: %s\n\n", watcher.Err())
+					flusher.Flush()
+				}
+				return
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", ev.Version, ev.Type, data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(rw, ": ping\n\n")
+			flusher.Flush()
+		case <-gate.closing:
+			return
+		}
+	}
+}
+
+// clearWriteDeadline ...
+// Lifts the server write timeout for a long-lived response, which would otherwise cut it off
+// Writers without deadline support, like test recorders, are left as they are
+func clearWriteDeadline(rw http.ResponseWriter) {
+	_ = http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+}
@@ -0,0 +1,163 @@
+package controller_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getircase/controller"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemDbHandlerWatch(t *testing.T) {
+	mgr := newMemDB(t)
+	gate := controller.NewMemDbGate(mgr)
+	server := httptest.NewServer(gate)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/in-memory/watch?prefix=tabs/", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the watch is registered before the response starts, a single write arrives
+	require.NoError(t, mgr.SetKV("tabs/1", "open"))
+	var ev models.KVEvent
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+			break
+		}
+	}
+	require.Equal(t, "set", event)
+	require.Equal(t, "tabs/1", ev.Key)
+	require.Equal(t, "open", ev.Value.String())
+
+	// closing the watchers ends the stream
+	gate.CloseWatchers()
+	for scanner.Scan() {
+	}
+
+	// only GET is served
+	rr := httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/in-memory/watch", nil)
+	require.NoError(t, err)
+	gate.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+// readEvent ...
+// Reads the next event of an SSE stream, its id and data
+func readEvent(t *testing.T, scanner *bufio.Scanner) (string, models.KVEvent) {
+	var id string
+	var ev models.KVEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimPrefix(line, "id: ")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+			return id, ev
+		}
+	}
+	t.Fatalf("stream ended: %v", scanner.Err())
+	return "", ev
+}
+
+func TestMemDbHandlerWatchWriteTimeout(t *testing.T) {
+	mgr := newMemDB(t)
+	gate := controller.NewMemDbGate(mgr)
+	server := httptest.NewUnstartedServer(gate)
+	server.Config.WriteTimeout = 500 * time.Millisecond
+	server.Start()
+	defer server.Close()
+	defer gate.CloseWatchers()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/in-memory/watch?prefix=tabs/", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the stream outlives the server write timeout
+	time.Sleep(time.Second)
+	require.NoError(t, mgr.SetKV("tabs/1", "open"))
+	_, ev := readEvent(t, bufio.NewScanner(resp.Body))
+	require.Equal(t, "tabs/1", ev.Key)
+}
+
+func TestMemDbHandlerWatchLastEventID(t *testing.T) {
+	mgr := newMemDB(t)
+	gate := controller.NewMemDbGate(mgr)
+	server := httptest.NewServer(gate)
+	defer server.Close()
+	defer gate.CloseWatchers()
+
+	require.NoError(t, mgr.SetKV("tabs/1", "seen"))
+	out, err := mgr.Retrieve("tabs/1")
+	require.NoError(t, err)
+	require.NoError(t, mgr.SetKV("tabs/2", "missed"))
+
+	// a reconnecting client gets what changed after the last event it saw
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/in-memory/watch?prefix=tabs/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(uint64(out.Version), 10))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	id, ev := readEvent(t, bufio.NewScanner(resp.Body))
+	require.Equal(t, "tabs/2", ev.Key)
+	require.Equal(t, strconv.FormatUint(uint64(ev.Version), 10), id)
+
+	// a malformed id throw http.StatusBadRequest
+	rr := httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/in-memory/watch", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "x")
+	gate.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// more changes than can be replayed throw http.StatusGone, so EventSource stops reconnecting
+	for i := 0; i <= 1024; i++ {
+		require.NoError(t, mgr.SetKV("tabs/many/"+strconv.Itoa(i), "x"))
+	}
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/in-memory/watch?prefix=tabs/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(uint64(out.Version), 10))
+	gate.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusGone, rr.Code)
+	require.NotContains(t, rr.Header().Get("Content-Type"), "text/event-stream")
+
+	// a reserved prefix throw http.StatusBadRequest
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/in-memory/watch?prefix=%00team-red", nil)
+	require.NoError(t, err)
+	gate.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getircase/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Media types of the streamed records
+// ndjson is one JSON document per line, csv has the columns of csvColumns after a header row
+const (
+	ndjsonType = "application/x-ndjson"
+	csvType    = "text/csv"
+)
+
+// csvColumns ...
+// Record fields written as CSV columns, in order
+var csvColumns = []string{"key", "createdAt", "totalCount"}
+
+// csvTime ...
+// Layout of createdAt in CSV, UTC without zone so spreadsheets read it as a date and time
+const csvTime = "2006-01-02 15:04:05"
+
+// recordEncoder ...
+// Writes streamed records in one format
+// head runs once before the first record, record writes one record
+type recordEncoder interface {
+	head() error
+	record(record bson.M) error
+}
+
+// streamFailer ...
+// Implemented by encoders whose streams are marked when they fail midway
+// fail writes the error if the format can tell it apart from a record, the response is aborted after it
+type streamFailer interface {
+	fail(err error) error
+}
+
+// ndjsonEncoder ...
+// Writes every record as a JSON document on its own line
+type ndjsonEncoder struct {
+	w io.Writer
+}
+
+func (e ndjsonEncoder) head() error { return nil }
+
+func (e ndjsonEncoder) record(record bson.M) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+// fail ...
+// Ends the stream with a line holding only the error, {"error": "..."}
+func (e ndjsonEncoder) fail(err error) error {
+	line, _ := json.Marshal(map[string]string{"error": err.Error()})
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+// csvEncoder ...
+// Writes records as RFC 4180 CSV rows with CRLF line endings, quoting cells as needed
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+// newCSVEncoder ...
+// Returns the CSV encoder writing to w
+func newCSVEncoder(w io.Writer) csvEncoder {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	return csvEncoder{w: cw}
+}
+
+func (e csvEncoder) head() error {
+	return e.write(csvColumns)
+}
+
+func (e csvEncoder) record(record bson.M) error {
+	row := make([]string, len(csvColumns))
+	for i, column := range csvColumns {
+		row[i] = csvCell(record[column])
+	}
+	return e.write(row)
+}
+
+// fail ...
+// CSV has no row to tell an error apart from a record, the aborted response alone marks it
+func (e csvEncoder) fail(err error) error {
+	return nil
+}
+
+// write ...
+// Writes row and flushes it to the underlying writer
+func (e csvEncoder) write(row []string) error {
+	if err := e.w.Write(row); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvCell ...
+// Text of a record field in a CSV cell
+// Text starting like a spreadsheet formula is prefixed with ' so pasting it never runs a formula
+func csvCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case primitive.DateTime:
+		return v.Time().UTC().Format(csvTime)
+	case time.Time:
+		return v.UTC().Format(csvTime)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvFilename ...
+// Download name of the CSV export of content, named after its date range, open ends read open
+func csvFilename(content models.MongoRequest) string {
+	start, end := content.StartDate, content.EndDate
+	if start == "" {
+		start = "open"
+	}
+	if end == "" {
+		end = "open"
+	}
+	// timestamps keep no colons, they are not allowed in file names everywhere
+	return strings.ReplaceAll(fmt.Sprintf("records_%s_%s.csv", start, end), ":", "")
+}
@@ -0,0 +1,105 @@
+package controller_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getircase/controller"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongoHandlerCSV(t *testing.T) {
+	payloadBytes := []byte(`{"startDate": "2016-01-02", "endDate": "2016-06-02", "minCount": 2900, "maxCount": 3000}`)
+	createdAt := primitive.NewDateTimeFromTime(time.Date(2016, 1, 28, 7, 10, 33, 0, time.UTC))
+	records := []bson.M{
+		{"key": "TAKwGc6Jr4i8Z487", "createdAt": createdAt, "totalCount": int32(2992)},
+		{"key": `a,"b"`, "createdAt": createdAt, "totalCount": 2950.5},
+		{"key": "=SUM(A1)", "createdAt": createdAt, "totalCount": int64(2901)},
+	}
+	for _, set := range []func(req *http.Request){
+		func(req *http.Request) { req.Header.Set("Accept", "text/csv") },
+		func(req *http.Request) { req.URL.RawQuery = "format=csv" },
+	} {
+		fake := &fakeMongo{out: models.MongoResponse{Msg: "Success"}, records: records}
+		req, err := http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+		require.NoError(t, err)
+		set(req)
+		rr := httptest.NewRecorder()
+		controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusAccepted, rr.Code)
+		require.Equal(t, "text/csv; charset=utf-8; header=present", rr.Header().Get("Content-Type"))
+		require.Equal(t, `attachment; filename=records_2016-01-02_2016-06-02.csv`, rr.Header().Get("Content-Disposition"))
+		require.Contains(t, rr.Body.String(), "\r\n")
+		rows, err := csv.NewReader(rr.Body).ReadAll()
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"key", "createdAt", "totalCount"},
+			{"TAKwGc6Jr4i8Z487", "2016-01-28 07:10:33", "2992"},
+			{`a,"b"`, "2016-01-28 07:10:33", "2950.5"},
+			{"'=SUM(A1)", "2016-01-28 07:10:33", "2901"},
+		}, rows)
+	}
+
+	// open ends of the date range name the download open
+	fake := &fakeMongo{out: models.MongoResponse{Msg: "Success"}, records: records}
+	req, err := http.NewRequest("POST", "/mongo?format=csv", bytes.NewReader([]byte(`{"startDate": "2016-01-02"}`)))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, `attachment; filename=records_2016-01-02_open.csv`, rr.Header().Get("Content-Disposition"))
+
+	// timestamps lose their colons
+	req, err = http.NewRequest("POST", "/mongo?format=csv", bytes.NewReader([]byte(`{"startDate": "2016-01-02T10:00:00Z", "endDate": "now"}`)))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, `attachment; filename=records_2016-01-02T100000Z_now.csv`, rr.Header().Get("Content-Disposition"))
+
+	// no records is answered as JSON without a download
+	fake = &fakeMongo{out: models.MongoResponse{Code: http.StatusNoContent, Msg: "No Data Found", Records: []bson.M{}}, err: errNoData}
+	req, err = http.NewRequest("POST", "/mongo?format=csv", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Empty(t, rr.Header().Get("Content-Disposition"))
+	require.Contains(t, rr.Body.String(), "No Data Found")
+}
+
+func TestMongoHandlerCSVServer(t *testing.T) {
+	payloadBytes := []byte(`{"startDate": "2016-01-02", "endDate": "2016-06-02"}`)
+	export := func(fake *fakeMongo) ([][]string, error) {
+		server := httptest.NewUnstartedServer(controller.NewMongoDbGate(fake))
+		server.Config.WriteTimeout = 300 * time.Millisecond
+		server.Start()
+		defer server.Close()
+		resp, err := http.Post(server.URL+"/mongo?format=csv", "application/json", bytes.NewReader(payloadBytes))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		body, err := ioutil.ReadAll(resp.Body)
+		rows, csvErr := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		require.NoError(t, csvErr)
+		return rows, err
+	}
+
+	// a long export is not cut by the server write timeout
+	records := []bson.M{{"key": "a"}, {"key": "b"}, {"key": "c"}}
+	rows, err := export(&fakeMongo{out: models.MongoResponse{Msg: "Success"}, records: records, delay: 200 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+
+	// an export failing midway is truncated rather than ending like a complete file
+	rows, err = export(&fakeMongo{out: models.MongoResponse{}, records: records, err: fmt.Errorf("cursor lost")})
+	require.Error(t, err)
+	require.Len(t, rows, 4)
+}
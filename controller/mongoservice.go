@@ -3,7 +3,9 @@ package controller
 import (
 	"encoding/json"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/getircase/db"
 	"github.com/getircase/models"
@@ -11,13 +13,23 @@ import (
 )
 
 // MongoDbGate ...
-// In-Memory Gateway/handler for in-memory based request
-type MongoDbGate struct{}
+// MongoDb Gateway/handler for records based request
+type MongoDbGate struct {
+	mgr db.MongoManager
+}
+
+// NewMongoDbGate ...
+// Returns the records handler backed by the given manager
+func NewMongoDbGate(mgr db.MongoManager) *MongoDbGate {
+	return &MongoDbGate{mgr: mgr}
+}
 
 // ServeHTTP ...
 // Generic ServeHttp linked with MongodbGate
 // Serves HTTP method POST
 // uri path /mongo
+// Requests accepting application/x-ndjson get the records streamed one per line,
+// requests accepting text/csv or with format=csv get them streamed as CSV
 func (gate *MongoDbGate) ServeHTTP(rw http.ResponseWriter, request *http.Request) {
 	var result interface{}
 	var out []byte
@@ -63,12 +75,25 @@ func (gate *MongoDbGate) ServeHTTP(rw http.ResponseWriter, request *http.Request
 		_, _ = rw.Write(out)
 		return
 	}
+	switch {
+	case request.URL.Query().Get("format") == "csv" || accepts(request, csvType):
+		header := http.Header{}
+		header.Set("Content-Type", csvType+"; charset=utf-8; header=present")
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": csvFilename(content)}))
+		gate.stream(rw, request, content, header, newCSVEncoder(rw))
+		return
+	case accepts(request, ndjsonType):
+		header := http.Header{}
+		header.Set("Content-Type", ndjsonType)
+		gate.stream(rw, request, content, header, ndjsonEncoder{rw})
+		return
+	}
 	// Retrieve associated data for the requested filters
-	// If there is an error throw http.StatusNotFound
-	result, err = db.MongoMgr().Retrieve(content)
+	// If there is an error throw the status of the result, http.StatusNotFound when no record matches
+	result, err = gate.mgr.Retrieve(content)
 	out, _ = json.Marshal(result)
 	if err != nil {
-		rw.WriteHeader(http.StatusNotFound)
+		rw.WriteHeader(resultStatus(result))
 		_, _ = rw.Write(out)
 		return
 	}
@@ -77,3 +102,79 @@ func (gate *MongoDbGate) ServeHTTP(rw http.ResponseWriter, request *http.Request
 	rw.WriteHeader(http.StatusAccepted)
 	_, _ = rw.Write(out)
 }
+
+// stream ...
+// Writes the records of content with enc, flushing each record as the cursor decodes it
+// header is sent with the first record, the query ends with the request so a disconnected client stops it
+// Errors before the first record are answered like Retrieve errors, later ones end the stream
+// and abort the response when enc is a streamFailer
+func (gate *MongoDbGate) stream(rw http.ResponseWriter, request *http.Request, content models.MongoRequest, header http.Header, enc recordEncoder) {
+	flusher, _ := rw.(http.Flusher)
+	// a large result set streams for longer than the server write timeout allows
+	clearWriteDeadline(rw)
+	started := false
+	result, err := gate.mgr.Stream(request.Context(), content, func(record bson.M) error {
+		if !started {
+			for name, values := range header {
+				rw.Header()[name] = values
+			}
+			rw.Header().Set("X-Accel-Buffering", "no")
+			rw.WriteHeader(http.StatusAccepted)
+			started = true
+			if err := enc.head(); err != nil {
+				return err
+			}
+		}
+		if err := enc.record(record); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if started {
+		// a failed stream must not look complete, the format writes its error if it has a way to,
+		// then the connection is aborted without the final chunk so clients see a truncated body
+		if failer, ok := enc.(streamFailer); ok && err != nil && request.Context().Err() == nil {
+			_ = failer.fail(err)
+			if flusher != nil {
+				flusher.Flush()
+			}
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	out, _ := json.Marshal(result)
+	if err != nil {
+		rw.WriteHeader(resultStatus(result))
+		_, _ = rw.Write(out)
+		return
+	}
+	rw.WriteHeader(http.StatusAccepted)
+	_, _ = rw.Write(out)
+}
+
+// resultStatus ...
+// Status of a failed query, the code of its MongoResponse for an invalid request (400)
+// or a database failure (500) and http.StatusNotFound otherwise, like when no record matches
+func resultStatus(result interface{}) int {
+	if mr, ok := result.(models.MongoResponse); ok {
+		switch mr.Code {
+		case http.StatusBadRequest, http.StatusInternalServerError:
+			return mr.Code
+		}
+	}
+	return http.StatusNotFound
+}
+
+// accepts ...
+// Reports whether the Accept header of request lists mediaType, parameters are ignored
+func accepts(request *http.Request, mediaType string) bool {
+	for _, accept := range strings.Split(request.Header.Get("Accept"), ",") {
+		if t, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && t == mediaType {
+			return true
+		}
+	}
+	return false
+}
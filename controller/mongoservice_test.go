@@ -2,11 +2,15 @@ package controller_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/getircase/controller"
 	"github.com/getircase/models"
@@ -14,13 +18,52 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+// fakeMongo ...
+// MongoManager returning a canned response, counting calls and keeping the last input
+// Stream hands records to the callback, waiting delay before each, then answers out and err
+type fakeMongo struct {
+	out     interface{}
+	err     error
+	calls   int
+	in      interface{}
+	records []bson.M
+	delay   time.Duration
+}
+
+// errNoData ...
+// Error of a manager finding no records
+var errNoData = fmt.Errorf("no data found")
+
+func (f *fakeMongo) Retrieve(input interface{}) (out interface{}, err error) {
+	f.calls++
+	f.in = input
+	return f.out, f.err
+}
+
+func (f *fakeMongo) Stream(ctx context.Context, input interface{}, fn func(record bson.M) error) (out interface{}, err error) {
+	f.calls++
+	f.in = input
+	for _, record := range f.records {
+		time.Sleep(f.delay)
+		if err = ctx.Err(); err != nil {
+			return f.out, err
+		}
+		if err = fn(record); err != nil {
+			return f.out, err
+		}
+	}
+	return f.out, f.err
+}
+
+func (f *fakeMongo) Close() error { return nil }
+
 func TestMongoHandlerHttpMethodError(t *testing.T) {
 	var mongoServer http.Handler
 	var req *http.Request
 	var err error
 	var body []byte
 	var mr models.MongoResponse
-	mongoServer = new(controller.MongoDbGate)
+	mongoServer = controller.NewMongoDbGate(new(fakeMongo))
 	// Bad Http Method Case
 	req, err = http.NewRequest("PUT", "/mongo", nil)
 	require.NoError(t, err)
@@ -47,7 +90,7 @@ func TestMongoHandlerNilBodyError(t *testing.T) {
 	var err error
 	var body []byte
 	var mr models.MongoResponse
-	mongoServer = new(controller.MongoDbGate)
+	mongoServer = controller.NewMongoDbGate(new(fakeMongo))
 	// Nil body Error Case
 	// Create a Http.POST request to pass to our handler.
 	req, err = http.NewRequest("POST", "/mongo", nil)
@@ -78,7 +121,7 @@ func TestMongoHandlerModelError(t *testing.T) {
 	rq := map[interface{}]interface{}{}
 	// Bad Request Body Case
 	rq["abc"] = 1.1
-	mongoServer = new(controller.MongoDbGate)
+	mongoServer = controller.NewMongoDbGate(new(fakeMongo))
 	payloadBytes, _ := json.Marshal(rq)
 	reader := bytes.NewReader(payloadBytes)
 	// Create a Http.POST request to pass to our handler.
@@ -101,7 +144,22 @@ func TestMongoHandlerModelError(t *testing.T) {
 	require.Equal(t, mr.Records, []bson.M{})
 }
 
+func TestMongoHandlerTruncatedBody(t *testing.T) {
+	// a truncated JSON document throw http.StatusInternalServerError
+	fake := new(fakeMongo)
+	req, err := http.NewRequest("POST", "/mongo", strings.NewReader(`{"startDate": "2016-01-02"`))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	var mr models.MongoResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &mr))
+	require.Equal(t, "unexpected end of JSON input", mr.Msg)
+	require.Zero(t, fake.calls)
+}
+
 func TestMongoHandlerResponseError(t *testing.T) {
+	mgr := requireMongo(t)
 	var mongoServer http.Handler
 	var req *http.Request
 	var err error
@@ -114,7 +172,7 @@ func TestMongoHandlerResponseError(t *testing.T) {
 	rq["endDate"] = "2016-03-02"
 	rq["minCount"] = 3100
 	rq["maxCount"] = 3000
-	mongoServer = new(controller.MongoDbGate)
+	mongoServer = controller.NewMongoDbGate(mgr)
 	payloadBytes, _ := json.Marshal(rq)
 	reader := bytes.NewReader(payloadBytes)
 	// Create a Http.POST request to pass to our handler.
@@ -126,7 +184,7 @@ func TestMongoHandlerResponseError(t *testing.T) {
 	// directly and pass in our Request and ResponseRecorder.
 	mongoServer.ServeHTTP(rr, req)
 	// Check the status code is what we expect.
-	require.Equal(t, rr.Code, http.StatusNotFound)
+	require.Equal(t, rr.Code, http.StatusBadRequest)
 	// Read the response body
 	body, err = ioutil.ReadAll(rr.Body)
 	require.NoError(t, err)
@@ -136,9 +194,43 @@ func TestMongoHandlerResponseError(t *testing.T) {
 	require.Equal(t, mr.Msg, "parsing time \"2016-01-32\": day out of range")
 	require.Equal(t, mr.Records, []bson.M{})
 
-	// No records found for the requested filters
+	// min greater than max is refused up front
 	rq["startDate"] = "2016-01-02"
 	payloadBytes, _ = json.Marshal(rq)
+	req, err = http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	mongoServer.ServeHTTP(rr, req)
+	require.Equal(t, rr.Code, http.StatusBadRequest)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &mr))
+	require.Equal(t, mr.Code, http.StatusBadRequest)
+	require.Equal(t, mr.Msg, "minCount must not be greater than maxCount")
+
+	// an unknown timezone or a relative date beyond time.Duration is refused, streamed or not
+	for _, c := range []struct {
+		payload string
+		msg     string
+	}{
+		{`{"startDate": "2016-01-02", "timezone": "Mars/Olympus"}`, `unknown timezone "Mars/Olympus"`},
+		{`{"startDate": "now-2562048h"}`, `relative date "now-2562048h" is out of range`},
+	} {
+		for _, accept := range []string{"", "application/x-ndjson"} {
+			req, err = http.NewRequest("POST", "/mongo", strings.NewReader(c.payload))
+			require.NoError(t, err)
+			req.Header.Set("Accept", accept)
+			rr = httptest.NewRecorder()
+			mongoServer.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &mr))
+			require.Equal(t, c.msg, mr.Msg)
+		}
+	}
+
+	// No records found for the requested filters
+	rq["startDate"] = "2030-01-02"
+	rq["endDate"] = "2030-03-02"
+	rq["minCount"] = 2900
+	payloadBytes, _ = json.Marshal(rq)
 	reader = bytes.NewReader(payloadBytes)
 	// Create a Http.POST request to pass to our handler.
 	req, err = http.NewRequest("POST", "/mongo", reader)
@@ -161,6 +253,7 @@ func TestMongoHandlerResponseError(t *testing.T) {
 }
 
 func TestMongoDbHandlerPostSuccess(t *testing.T) {
+	mgr := requireMongo(t)
 	var mongoServer http.Handler
 	var req *http.Request
 	var err error
@@ -168,7 +261,7 @@ func TestMongoDbHandlerPostSuccess(t *testing.T) {
 	var resp models.MongoResponse
 	rq := map[string]interface{}{}
 	// Generate Body object to pass to request
-	mongoServer = new(controller.MongoDbGate)
+	mongoServer = controller.NewMongoDbGate(mgr)
 	rq["startDate"] = "2016-01-02"
 	rq["endDate"] = "2016-06-02"
 	rq["minCount"] = 2900
@@ -194,3 +287,180 @@ func TestMongoDbHandlerPostSuccess(t *testing.T) {
 	require.Equal(t, resp.Msg, "Success")
 	require.LessOrEqual(t, 0, len(resp.Records))
 }
+
+func TestMongoHandlerFakeManager(t *testing.T) {
+	var body []byte
+	var mr models.MongoResponse
+	rq := map[string]interface{}{}
+	rq["startDate"] = "2016-01-02"
+	rq["endDate"] = "2016-06-02"
+	rq["minCount"] = 2900
+	rq["maxCount"] = 3000
+	payloadBytes, _ := json.Marshal(rq)
+	// Success response of the manager is written with http.StatusAccepted
+	fake := &fakeMongo{out: models.MongoResponse{Msg: "Success", Records: []bson.M{{"key": "abc"}}}}
+	req, err := http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.Equal(t, 1, fake.calls)
+	body, err = ioutil.ReadAll(rr.Body)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(body, &mr))
+	require.Equal(t, "Success", mr.Msg)
+	require.Equal(t, "abc", mr.Records[0]["key"])
+
+	// Manager error is written with http.StatusNotFound
+	fake = &fakeMongo{out: models.MongoResponse{Code: http.StatusNoContent, Msg: "No Data Found", Records: []bson.M{}}, err: fmt.Errorf("no data found")}
+	req, err = http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	body, err = ioutil.ReadAll(rr.Body)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(body, &mr))
+	require.Equal(t, http.StatusNoContent, mr.Code)
+
+	// an invalid request or a database failure is written with the code of the result, streamed or not
+	for _, c := range []struct {
+		code int
+		msg  string
+	}{
+		{http.StatusBadRequest, "minCount must not be greater than maxCount"},
+		{http.StatusBadRequest, "startDate must not be after endDate"},
+		{http.StatusBadRequest, "limit must be between 1 and 1000"},
+		{http.StatusBadRequest, "invalid cursor"},
+		{http.StatusInternalServerError, "server selection timeout"},
+	} {
+		for _, accept := range []string{"", "application/x-ndjson", "text/csv"} {
+			fake = &fakeMongo{out: models.MongoResponse{Code: c.code, Msg: c.msg, Records: []bson.M{}}, err: fmt.Errorf("%s", c.msg)}
+			req, err = http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+			require.NoError(t, err)
+			req.Header.Set("Accept", accept)
+			rr = httptest.NewRecorder()
+			controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+			require.Equal(t, c.code, rr.Code, "%s %s", accept, c.msg)
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &mr))
+			require.Equal(t, c.msg, mr.Msg)
+		}
+	}
+}
+
+func TestMongoHandlerPaging(t *testing.T) {
+	var mr models.MongoResponse
+	payloadBytes := []byte(`{"startDate": "2016-01-02", "endDate": "2016-06-02", "minCount": 2900, "maxCount": 3000,
+		"limit": 2, "cursor": "abc", "sort": "totalCount", "order": "desc"}`)
+	// Paging fields reach the manager, total and nextCursor reach the client
+	fake := &fakeMongo{out: models.MongoResponse{Msg: "Success", Total: 5, NextCursor: "def", Records: []bson.M{{"key": "a"}, {"key": "b"}}}}
+	req, err := http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	in := fake.in.(models.MongoRequest)
+	require.Equal(t, 2, in.Limit)
+	require.Equal(t, "abc", in.Cursor)
+	require.Equal(t, "totalCount", in.Sort)
+	require.Equal(t, "desc", in.Order)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &mr))
+	require.Equal(t, int64(5), mr.Total)
+	require.Equal(t, "def", mr.NextCursor)
+	require.Len(t, mr.Records, 2)
+
+	// the last page has no nextCursor
+	fake.out = models.MongoResponse{Msg: "Success", Total: 5, Records: []bson.M{{"key": "e"}}}
+	req, err = http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.NotContains(t, rr.Body.String(), "nextCursor")
+}
+
+func TestMongoHandlerStream(t *testing.T) {
+	payloadBytes := []byte(`{"startDate": "2016-01-02", "endDate": "2016-06-02", "minCount": 2900, "maxCount": 3000}`)
+	// records are written one JSON document per line
+	fake := &fakeMongo{out: models.MongoResponse{Msg: "Success"}, records: []bson.M{{"key": "a", "totalCount": 2950}, {"key": "b", "totalCount": 2990}}}
+	req, err := http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/html, application/x-ndjson;q=0.9")
+	rr := httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	require.True(t, rr.Flushed)
+	require.Equal(t, 1, fake.calls)
+	lines := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	require.Equal(t, "b", record["key"])
+	require.Equal(t, 2990.0, record["totalCount"])
+
+	// an error before the first record is answered like a Retrieve error
+	fake = &fakeMongo{out: models.MongoResponse{Code: http.StatusNoContent, Msg: "No Data Found", Records: []bson.M{}}, err: fmt.Errorf("no data found")}
+	req, err = http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/x-ndjson")
+	rr = httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	var mr models.MongoResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &mr))
+	require.Equal(t, http.StatusNoContent, mr.Code)
+
+	// a disconnected client ends the stream
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fake = &fakeMongo{out: models.MongoResponse{Msg: "Success"}, records: []bson.M{{"key": "a"}}}
+	req, err = http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/x-ndjson")
+	rr = httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req.WithContext(ctx))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.NotContains(t, rr.Body.String(), `"key"`)
+
+	// without the Accept header the records are buffered as before
+	fake = &fakeMongo{out: models.MongoResponse{Msg: "Success", Records: []bson.M{{"key": "a"}}}}
+	req, err = http.NewRequest("POST", "/mongo", bytes.NewReader(payloadBytes))
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	controller.NewMongoDbGate(fake).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	require.NotEqual(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+}
+
+func TestMongoHandlerStreamServer(t *testing.T) {
+	payloadBytes := []byte(`{"startDate": "2016-01-02", "endDate": "2016-06-02", "minCount": 2900, "maxCount": 3000}`)
+	post := func(fake *fakeMongo) (string, error) {
+		server := httptest.NewUnstartedServer(controller.NewMongoDbGate(fake))
+		server.Config.WriteTimeout = 300 * time.Millisecond
+		server.Start()
+		defer server.Close()
+		req, err := http.NewRequest("POST", server.URL+"/mongo", bytes.NewReader(payloadBytes))
+		require.NoError(t, err)
+		req.Header.Set("Accept", "application/x-ndjson")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// the stream outlives the server write timeout
+	records := []bson.M{{"key": "a"}, {"key": "b"}, {"key": "c"}}
+	body, err := post(&fakeMongo{out: models.MongoResponse{Msg: "Success"}, records: records, delay: 200 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSuffix(body, "\n"), "\n"), 3)
+
+	// an error after the first record ends with an error line and a truncated body
+	body, err = post(&fakeMongo{out: models.MongoResponse{}, records: records, err: fmt.Errorf("cursor lost")})
+	require.Error(t, err)
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	require.Len(t, lines, 4)
+	require.JSONEq(t, `{"error": "cursor lost"}`, lines[3])
+}
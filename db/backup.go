@@ -0,0 +1,69 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxPendingWrites ...
+// Number of in-flight batches badger keeps while loading a backup
+const maxPendingWrites = 256
+
+// Backup ...
+// Streams a badger backup of every entry with a version above since into w
+// since 0 dumps the full store, the returned value is the since to pass
+// for the next incremental backup
+// badger v3 skips versions at or below since despite documenting the bound as inclusive,
+// so the last dumped version is returned as is rather than incremented
+// The backup reads a snapshot and only waits for a running restore, a slow writer holds up nothing else
+func (m *memdb) Backup(w io.Writer, since uint64) (uint64, error) {
+	m.loadMu.RLock()
+	defer m.loadMu.RUnlock()
+	last, err := m.db.Backup(w, since)
+	if err != nil {
+		return since, err
+	}
+	if last < since {
+		return since, nil
+	}
+	return last, nil
+}
+
+// Load ...
+// Repopulates the store from a badger backup stream
+// Full and incremental backups must be loaded in the order they were taken
+// Other transactions wait until the load completes, the load waits for running backups
+// badger trusts the length prefixes of the stream and panics on garbage,
+// which is reported as an error instead
+func (m *memdb) Load(r io.Reader) (err error) {
+	m.loadMu.Lock()
+	defer m.loadMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("corrupt backup: %v", p)
+		}
+	}()
+	// loaded keys change the namespace usage and the store size, count again on the next write
+	defer m.resetUsage()
+	if m.tracker != nil {
+		defer m.tracker.invalidate()
+	}
+	return m.db.Load(r, maxPendingWrites)
+}
+
+// restoreFile ...
+// Loads the backup file at path, used at startup
+func (m *memdb) restoreFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("badger restore: %v", err)
+	}
+	defer f.Close()
+	if err = m.Load(f); err != nil {
+		return fmt.Errorf("badger restore %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package db_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getircase/config"
+	"github.com/getircase/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemDbBackupRestore(t *testing.T) {
+	src := newMemDB(t)
+	require.NoError(t, src.SetKV("a", "1"))
+	require.NoError(t, src.SetKV("b", "2"))
+	// full backup
+	var full bytes.Buffer
+	since, err := src.Backup(&full, 0)
+	require.NoError(t, err)
+	require.NotZero(t, since)
+	// incremental backup only carries the later writes
+	require.NoError(t, src.SetKV("c", "3"))
+	require.NoError(t, src.Delete("a"))
+	var incr bytes.Buffer
+	next, err := src.Backup(&incr, since)
+	require.NoError(t, err)
+	require.Greater(t, next, since)
+
+	// full then incremental rebuilds the same state
+	dst := newMemDB(t)
+	require.NoError(t, dst.Load(bytes.NewReader(full.Bytes())))
+	out, err := dst.Retrieve("a")
+	require.NoError(t, err)
+	require.Equal(t, "1", out.Value.String())
+	require.NoError(t, dst.Load(bytes.NewReader(incr.Bytes())))
+	page, err := dst.List("", 0, "", true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "c"}, page.Keys)
+	require.Equal(t, "3", page.Values["c"].String())
+
+	// the store keeps working after a load
+	require.NoError(t, dst.SetKV("d", "4"))
+
+	// garbage is rejected
+	require.Error(t, dst.Load(bytes.NewReader([]byte("not a backup"))))
+}
+
+func TestInMemDbRestoreAtStartup(t *testing.T) {
+	src := newMemDB(t)
+	require.NoError(t, src.SetKV("kept", "yes"))
+	var buf bytes.Buffer
+	_, err := src.Backup(&buf, 0)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "memdb.bak")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.RestoreFrom = path
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+	out, err := mgr.Retrieve("kept")
+	require.NoError(t, err)
+	require.Equal(t, "yes", out.Value.String())
+
+	// a missing file fails the startup
+	cfg.RestoreFrom = filepath.Join(t.TempDir(), "absent.bak")
+	_, err = db.NewMemDBManager(cfg)
+	require.Error(t, err)
+}
+
+// stallWriter ...
+// Writer blocking its first write until release is closed, started is closed once it blocks
+type stallWriter struct {
+	started, release chan struct{}
+	once             sync.Once
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	return len(p), nil
+}
+
+func TestInMemDbBackupSlowClient(t *testing.T) {
+	mgr := newMemDB(t)
+	red, err := mgr.Namespace("team-red")
+	require.NoError(t, err)
+	require.NoError(t, red.SetKV("active-tabs", "3"))
+	w := &stallWriter{started: make(chan struct{}), release: make(chan struct{})}
+	backupDone := make(chan error, 1)
+	go func() {
+		_, err := mgr.Backup(w, 0)
+		backupDone <- err
+	}()
+	<-w.started
+
+	// a backup stuck on its client holds up neither drops nor reads and writes
+	done := make(chan error, 1)
+	go func() {
+		if err := mgr.DropNamespace("team-red"); err != nil {
+			done <- err
+			return
+		}
+		if err := mgr.SetKV("active-tabs", "4"); err != nil {
+			done <- err
+			return
+		}
+		_, err := mgr.Retrieve("active-tabs")
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("store blocked by a slow backup")
+	}
+	close(w.release)
+	require.NoError(t, <-backupDone)
+}
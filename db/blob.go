@@ -0,0 +1,111 @@
+package db
+
+import (
+	"encoding/binary"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/getircase/models"
+)
+
+// Content types reported for values that were not stored raw
+const (
+	contentTypeOctet = "application/octet-stream"
+	contentTypeText  = "text/plain; charset=utf-8"
+	contentTypeJSON  = "application/json"
+)
+
+// frameBlob ...
+// Stored bytes of a raw value, the uvarint length of the content type, the content type and the data
+func frameBlob(contentType string, data []byte) []byte {
+	out := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(contentType)+len(data))
+	n := binary.PutUvarint(out, uint64(len(contentType)))
+	out = append(append(out[:n], contentType...), data...)
+	return out
+}
+
+// unframeBlob ...
+// Content type and data of the stored bytes of a raw value
+// a damaged frame reads as data without content type
+func unframeBlob(raw []byte) (string, []byte) {
+	size, n := binary.Uvarint(raw)
+	if n <= 0 || uint64(len(raw)-n) < size {
+		return "", raw
+	}
+	return string(raw[n : n+int(size)]), raw[n+int(size):]
+}
+
+// blobType ...
+// Content type of stored bytes written with the given user meta, empty unless they are a raw value
+func blobType(raw []byte, meta byte) string {
+	if meta&metaBlob == 0 {
+		return ""
+	}
+	contentType, _ := unframeBlob(raw)
+	return contentType
+}
+
+// SetBlob ...
+// Set key and the raw bytes of blob kept with its content type, which badger expires after ttl
+// An empty content type is stored as application/octet-stream
+// return ErrValueTooLarge when the bytes exceed the max value size
+func (m *memdb) SetBlob(key string, blob models.Blob, ttl time.Duration) error {
+	k, err := m.key(key)
+	if err != nil {
+		return err
+	}
+	if err = m.checkSize(len(blob.Data)); err != nil {
+		return err
+	}
+	if blob.ContentType == "" {
+		blob.ContentType = contentTypeOctet
+	}
+	raw, meta := m.compress(frameBlob(blob.ContentType, blob.Data), metaLive|metaBlob)
+	return m.write(func(txn *badger.Txn, d *change) error {
+		e := newEntry(k, raw).WithMeta(meta)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+		return m.setEntry(txn, d, e)
+	})
+}
+
+// RetrieveBlob ...
+// fetches the bytes of the key with their content type
+// strings read as text/plain and JSON documents as application/json
+// return err if Key not found or Key is empty
+func (m *memdb) RetrieveBlob(key string) (models.Blob, error) {
+	var out models.Blob
+	k, err := m.key(key)
+	if err != nil {
+		return out, err
+	}
+	err = m.view(func(txn *badger.Txn) error {
+		item, e := txn.Get(k)
+		if e != nil {
+			return e
+		}
+		raw, e := readValue(item)
+		if e != nil {
+			return e
+		}
+		switch meta := item.UserMeta(); {
+		case meta&metaBlob != 0:
+			out.ContentType, out.Data = unframeBlob(raw)
+		case meta&metaJSON != 0:
+			out.ContentType, out.Data = contentTypeJSON, raw
+		default:
+			out.ContentType, out.Data = contentTypeText, raw
+		}
+		out.Version = models.Version(item.Version())
+		if item.ExpiresAt() > 0 {
+			out.TTL = models.TTL(remaining(item.ExpiresAt()))
+		}
+		return nil
+	})
+	if err != nil {
+		return out, err
+	}
+	m.touch(k)
+	return out, nil
+}
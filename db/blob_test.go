@@ -0,0 +1,96 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/getircase/config"
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemDbBlob(t *testing.T) {
+	mgr := newMemDB(t)
+	png := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	require.NoError(t, mgr.SetBlob("logo", models.Blob{ContentType: "image/png", Data: png}, time.Hour))
+	blob, err := mgr.RetrieveBlob("logo")
+	require.NoError(t, err)
+	require.Equal(t, "image/png", blob.ContentType)
+	require.Equal(t, png, blob.Data)
+	require.NotZero(t, blob.Version)
+	require.NotZero(t, blob.TTL)
+
+	// JSON reads see the base64 of the bytes with the content type
+	out, err := mgr.Retrieve("logo")
+	require.NoError(t, err)
+	require.Equal(t, "iVBORwD/", out.Value.String())
+	require.Equal(t, "image/png", out.ContentType)
+	_, err = mgr.Incr("logo", 1)
+	require.Equal(t, db.ErrNotNumeric, err)
+
+	// a missing content type is stored as octet stream, empty bodies are kept
+	require.NoError(t, mgr.SetBlob("empty", models.Blob{}, 0))
+	blob, err = mgr.RetrieveBlob("empty")
+	require.NoError(t, err)
+	require.Equal(t, "application/octet-stream", blob.ContentType)
+	require.Empty(t, blob.Data)
+
+	// other values read as text and JSON
+	require.NoError(t, mgr.SetKV("name", "getir"))
+	blob, err = mgr.RetrieveBlob("name")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain; charset=utf-8", blob.ContentType)
+	require.Equal(t, "getir", string(blob.Data))
+	require.NoError(t, mgr.Set("cfg", models.Value(`{"a":1}`), 0))
+	blob, err = mgr.RetrieveBlob("cfg")
+	require.NoError(t, err)
+	require.Equal(t, "application/json", blob.ContentType)
+	require.Equal(t, `{"a":1}`, string(blob.Data))
+
+	_, err = mgr.RetrieveBlob("missing")
+	require.Equal(t, db.ErrKeyNotFound, err)
+	require.Equal(t, db.ErrEmptyKey, mgr.SetBlob("", models.Blob{}, 0))
+}
+
+func TestInMemDbBlobWatch(t *testing.T) {
+	mgr := newMemDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := mgr.Watch(ctx, "img/", 0)
+	require.NoError(t, err)
+	// keep writing until the subscription is live
+	var ev models.KVEvent
+	for ok := false; !ok; {
+		require.NoError(t, mgr.SetBlob("img/1", models.Blob{ContentType: "image/gif", Data: []byte("GIF89a")}, 0))
+		select {
+		case ev = <-w.Events():
+			ok = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	require.Equal(t, "image/gif", ev.ContentType)
+	require.Equal(t, "R0lGODlh", ev.Value.String())
+}
+
+func TestInMemDbMaxValueSize(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.MaxValueSize = 4
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+
+	require.NoError(t, mgr.SetBlob("a", models.Blob{ContentType: "application/x-long-content-type", Data: []byte("1234")}, 0))
+	require.Equal(t, db.ErrValueTooLarge, mgr.SetBlob("b", models.Blob{Data: []byte("12345")}, 0))
+	require.NoError(t, mgr.SetKV("c", "1234"))
+	require.Equal(t, db.ErrValueTooLarge, mgr.SetKV("c", "12345"))
+	require.Equal(t, db.ErrValueTooLarge, mgr.CompareAndSet("c", models.Text("12345"), 0, 0))
+	err = mgr.SetBatch([]models.InMemory{{Key: "d", Value: models.Text("1")}, {Key: "e", Value: models.Value(`[1,2]`)}})
+	require.True(t, errors.Is(err, db.ErrValueTooLarge))
+	found, err := mgr.Exists("d")
+	require.NoError(t, err)
+	require.False(t, found)
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"expvar"
+	"sync"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression metrics published by expvar under "memdb"
+// compressionRatio is the plain bytes of compressed writes divided by the bytes stored for them
+var (
+	metricCompressed      = new(expvar.Int)
+	metricCompressedPlain = new(expvar.Int)
+	metricCompressedBytes = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("compressedWrites", metricCompressed)
+	metrics.Set("compressedPlainBytes", metricCompressedPlain)
+	metrics.Set("compressedBytes", metricCompressedBytes)
+	metrics.Set("compressionRatio", expvar.Func(func() interface{} {
+		stored := metricCompressedBytes.Value()
+		if stored == 0 {
+			return 0.0
+		}
+		return float64(metricCompressedPlain.Value()) / float64(stored)
+	}))
+}
+
+// zstd encoder and decoder shared by every store, EncodeAll and DecodeAll are safe for concurrent use
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec ...
+// Creates the shared zstd encoder and decoder on first use
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// compress ...
+// Stored bytes and user meta of raw, zstd compressed with metaZstd set when raw is larger
+// than the configured threshold and compressing saves space
+func (m *memdb) compress(raw []byte, meta byte) ([]byte, byte) {
+	if m.cfg.CompressAbove == 0 || int64(len(raw)) <= m.cfg.CompressAbove {
+		return raw, meta
+	}
+	enc, _, err := zstdCodec()
+	if err != nil {
+		return raw, meta
+	}
+	out := enc.EncodeAll(raw, make([]byte, 0, len(raw)))
+	if len(out) >= len(raw) {
+		return raw, meta
+	}
+	metricCompressed.Add(1)
+	metricCompressedPlain.Add(int64(len(raw)))
+	metricCompressedBytes.Add(int64(len(out)))
+	return out, meta | metaZstd
+}
+
+// inflate ...
+// Plain bytes of stored bytes written with the given user meta
+func inflate(raw []byte, meta byte) ([]byte, error) {
+	if meta&metaZstd == 0 {
+		return raw, nil
+	}
+	_, dec, err := zstdCodec()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(raw, nil)
+}
+
+// readValue ...
+// Plain bytes of the value of item, without write time and decompressed when it was stored compressed
+func readValue(item *badger.Item) ([]byte, error) {
+	raw, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	return plain(raw, item.UserMeta())
+}
@@ -0,0 +1,91 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getircase/config"
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+// newCompressedMemDB ...
+// Returns an in-memory store compressing values above 64 bytes, closed when the test ends
+func newCompressedMemDB(t *testing.T) db.MemDBManager {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.CompressAbove = 64
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = mgr.Close() })
+	return mgr
+}
+
+func TestInMemDbCompression(t *testing.T) {
+	mgr := newCompressedMemDB(t)
+	writes := metric(t, "compressedWrites")
+	doc := models.Value(`{"items":[` + strings.Repeat(`{"name":"tab","open":true},`, 100) + `{}]}`)
+	text := strings.Repeat("active-tabs ", 50)
+
+	// reads decompress transparently
+	require.NoError(t, mgr.Set("doc", doc, 0))
+	require.NoError(t, mgr.SetKV("text", text))
+	require.NoError(t, mgr.SetKV("small", "3"))
+	out, err := mgr.Retrieve("doc")
+	require.NoError(t, err)
+	require.Equal(t, string(doc), string(out.Value))
+	out, err = mgr.Retrieve("text")
+	require.NoError(t, err)
+	require.Equal(t, text, out.Value.String())
+	batch, err := mgr.GetBatch([]string{"doc", "text"})
+	require.NoError(t, err)
+	require.Equal(t, text, batch.Records[1].Value.String())
+	page, err := mgr.List("", 0, "", true)
+	require.NoError(t, err)
+	require.Equal(t, string(doc), string(page.Values["doc"]))
+	require.Equal(t, writes+2, metric(t, "compressedWrites"))
+
+	// raw values keep their bytes and content type
+	data := bytes.Repeat([]byte{0, 1, 2, 3}, 100)
+	require.NoError(t, mgr.SetBlob("blob", models.Blob{ContentType: "application/x-test", Data: data}, 0))
+	blob, err := mgr.RetrieveBlob("blob")
+	require.NoError(t, err)
+	require.Equal(t, "application/x-test", blob.ContentType)
+	require.Equal(t, data, blob.Data)
+
+	// refreshing the ttl keeps the value readable
+	require.NoError(t, mgr.Expire("doc", time.Hour))
+	out, err = mgr.Retrieve("doc")
+	require.NoError(t, err)
+	require.Equal(t, string(doc), string(out.Value))
+
+	ratio, err := strconv.ParseFloat(expvar.Get("memdb").(*expvar.Map).Get("compressionRatio").String(), 64)
+	require.NoError(t, err)
+	require.Greater(t, ratio, 1.0)
+}
+
+func TestInMemDbCompressionWatch(t *testing.T) {
+	mgr := newCompressedMemDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := mgr.Watch(ctx, "doc", 0)
+	require.NoError(t, err)
+	text := strings.Repeat("x", 200)
+	// keep writing until the subscription is live
+	var ev models.KVEvent
+	for ok := false; !ok; {
+		require.NoError(t, mgr.SetKV("doc", text))
+		select {
+		case ev = <-w.Events():
+			ok = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	require.Equal(t, text, ev.Value.String())
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// ErrStoreInUse ...
+// Returned when the store directory is locked by a running store
+var ErrStoreInUse = errors.New("store is in use, stop the server first")
+
+// RotateEncryptionKey ...
+// Re-encrypts the badger key registry of the disk store in dir with newKey
+// The data is encrypted with data keys kept in the registry, so rotating the master key rewrites only the registry
+// An empty oldKey opens a store that was not encrypted, an empty newKey removes the encryption of the registry
+// The store must not be open while its key is rotated, its directory lock is held during the rotation
+// return ErrStoreInUse while the store is open, err if dir holds no store or oldKey does not match
+func RotateEncryptionKey(dir string, oldKey, newKey []byte) error {
+	if _, err := os.Stat(filepath.Join(dir, badger.KeyRegistryFileName)); err != nil {
+		return fmt.Errorf("no badger store in %s: %v", dir, err)
+	}
+	// a running store keeps appending data keys to the registry file it opened, they would be lost
+	unlock, err := lockStore(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	opt := badger.KeyRegistryOptions{Dir: dir, ReadOnly: true, EncryptionKey: oldKey}
+	registry, err := badger.OpenKeyRegistry(opt)
+	if err != nil {
+		return fmt.Errorf("open key registry: %v", err)
+	}
+	opt.EncryptionKey = newKey
+	if err = badger.WriteKeyRegistry(registry, opt); err != nil {
+		return fmt.Errorf("write key registry: %v", err)
+	}
+	return nil
+}
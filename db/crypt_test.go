@@ -0,0 +1,90 @@
+package db_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/getircase/config"
+	"github.com/getircase/db"
+	"github.com/stretchr/testify/require"
+)
+
+// openEncrypted ...
+// Opens the disk store in dir with key, an empty key opens it without encryption
+func openEncrypted(dir, key string) (db.MemDBManager, error) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.Mode = config.MemDBModeDisk
+	cfg.Dir = dir
+	cfg.GCInterval = 0
+	cfg.EncryptionKey = key
+	cfg.IndexCacheSize = 1 << 20
+	return db.NewMemDBManager(cfg)
+}
+
+func TestInMemDbEncryption(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKey := "0123456789abcdef", "fedcba9876543210fedcba9876543210"
+	mgr, err := openEncrypted(dir, oldKey)
+	require.NoError(t, err)
+	require.NoError(t, mgr.SetKV("active-tabs", "3"))
+	require.NoError(t, mgr.Close())
+	// no file holds the key in plain text
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	for _, f := range files {
+		body, err := ioutil.ReadFile(f)
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(body, []byte("active-tabs")), f)
+	}
+
+	// the store does not open without its key
+	_, err = openEncrypted(dir, "")
+	require.Error(t, err)
+	_, err = openEncrypted(dir, newKey)
+	require.Error(t, err)
+
+	// after rotating only the new key opens it
+	require.Error(t, db.RotateEncryptionKey(dir, []byte(newKey), []byte(oldKey)))
+	require.NoError(t, db.RotateEncryptionKey(dir, []byte(oldKey), []byte(newKey)))
+	_, err = openEncrypted(dir, oldKey)
+	require.Error(t, err)
+	mgr, err = openEncrypted(dir, newKey)
+	require.NoError(t, err)
+	out, err := mgr.Retrieve("active-tabs")
+	require.NoError(t, err)
+	require.Equal(t, "3", out.Value.String())
+	require.NoError(t, mgr.Close())
+
+	// a directory without a store is refused
+	require.Error(t, db.RotateEncryptionKey(t.TempDir(), []byte(oldKey), []byte(newKey)))
+}
+
+func TestInMemDbRotateOpenStore(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKey := "0123456789abcdef", "fedcba9876543210fedcba9876543210"
+	mgr, err := openEncrypted(dir, oldKey)
+	require.NoError(t, err)
+	require.NoError(t, mgr.SetKV("active-tabs", "3"))
+
+	// an open store keeps its key
+	err = db.RotateEncryptionKey(dir, []byte(oldKey), []byte(newKey))
+	require.True(t, errors.Is(err, db.ErrStoreInUse))
+	require.NoError(t, mgr.Close())
+	mgr, err = openEncrypted(dir, oldKey)
+	require.NoError(t, err)
+
+	// the running store is not blocked by the failed rotation, once stopped the key rotates
+	require.NoError(t, mgr.SetKV("active-tabs", "4"))
+	require.NoError(t, mgr.Close())
+	require.NoError(t, db.RotateEncryptionKey(dir, []byte(oldKey), []byte(newKey)))
+	mgr, err = openEncrypted(dir, newKey)
+	require.NoError(t, err)
+	out, err := mgr.Retrieve("active-tabs")
+	require.NoError(t, err)
+	require.Equal(t, "4", out.Value.String())
+	require.NoError(t, mgr.Close())
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayout ...
+// Layout of a calendar date, read at midnight in the request timezone
+const dateLayout = "2006-01-02"
+
+// relativeDate ...
+// now optionally followed by a signed amount of seconds, minutes, hours, days or weeks, like now-7d
+var relativeDate = regexp.MustCompile(`^now(?:([+-])(\d+)([smhdw]))?$`)
+
+// relativeUnits ...
+// Length of each unit of a relative date, days and weeks are added as calendar days
+var relativeUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// location ...
+// Timezone the calendar dates of a request are read in, UTC when name is empty
+// return err when name is not an IANA timezone name
+func location(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
+
+// parseDate ...
+// Reads a date bound of a request relative to now
+// A calendar date like 2016-01-02 is midnight in loc, an RFC 3339 timestamp carries its own offset
+// and a relative date like now-7d is counted from now, days and weeks as calendar days in loc
+// return err when a relative amount overflows and the error of the calendar date layout for anything else
+func parseDate(value string, loc *time.Location, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(value, "now") {
+		m := relativeDate.FindStringSubmatch(value)
+		if m == nil {
+			return time.Time{}, fmt.Errorf("invalid relative date %q", value)
+		}
+		t := now.In(loc)
+		if m[1] == "" {
+			return t, nil
+		}
+		// an amount must fit a time.Duration in its unit, larger ones would wrap around
+		unit := relativeUnits[m[3]]
+		n, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil || n > math.MaxInt64/int64(unit) {
+			return time.Time{}, fmt.Errorf("relative date %q is out of range", value)
+		}
+		if m[1] == "-" {
+			n = -n
+		}
+		switch m[3] {
+		case "d":
+			return t.AddDate(0, 0, int(n)), nil
+		case "w":
+			return t.AddDate(0, 0, 7*int(n)), nil
+		default:
+			return t.Add(time.Duration(n) * unit), nil
+		}
+	}
+	if strings.Contains(value, "T") {
+		return time.Parse(time.RFC3339Nano, value)
+	}
+	return time.ParseInLocation(dateLayout, value, loc)
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocation(t *testing.T) {
+	loc, err := location("")
+	require.NoError(t, err)
+	require.Equal(t, time.UTC, loc)
+	loc, err = location("Europe/Istanbul")
+	require.NoError(t, err)
+	require.Equal(t, "Europe/Istanbul", loc.String())
+	_, err = location("Mars/Olympus")
+	require.EqualError(t, err, `unknown timezone "Mars/Olympus"`)
+}
+
+func TestParseDate(t *testing.T) {
+	istanbul, err := location("Europe/Istanbul")
+	require.NoError(t, err)
+	newYork, err := location("America/New_York")
+	require.NoError(t, err)
+	// New York moves its clocks forward an hour at 2021-03-14 02:00, now is 11:00 EDT that day
+	now := time.Date(2021, 3, 14, 15, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		value string
+		loc   *time.Location
+		want  time.Time
+	}{
+		// calendar dates are midnight in loc
+		{"2016-01-02", time.UTC, time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2016-01-02", istanbul, time.Date(2016, 1, 1, 22, 0, 0, 0, time.UTC)},
+		{"2021-01-02", istanbul, time.Date(2021, 1, 1, 21, 0, 0, 0, time.UTC)},
+		// timestamps carry their own offset
+		{"2016-01-02T10:00:00+05:30", istanbul, time.Date(2016, 1, 2, 4, 30, 0, 0, time.UTC)},
+		{"2016-01-02T10:00:00.5Z", newYork, time.Date(2016, 1, 2, 10, 0, 0, 5e8, time.UTC)},
+		// relative dates count from now
+		{"now", newYork, now},
+		{"now+90s", time.UTC, now.Add(90 * time.Second)},
+		{"now-30m", time.UTC, now.Add(-30 * time.Minute)},
+		{"now-24h", newYork, time.Date(2021, 3, 13, 15, 0, 0, 0, time.UTC)},
+		// days and weeks keep the wall clock across the change, 23 hours before is 11:00 EST
+		{"now-1d", newYork, time.Date(2021, 3, 13, 16, 0, 0, 0, time.UTC)},
+		{"now-1w", newYork, time.Date(2021, 3, 7, 16, 0, 0, 0, time.UTC)},
+		{"now-1d", time.UTC, time.Date(2021, 3, 13, 15, 0, 0, 0, time.UTC)},
+		{"now+1d", istanbul, time.Date(2021, 3, 15, 15, 0, 0, 0, time.UTC)},
+		{"now+9223372036s", time.UTC, now.Add(9223372036 * time.Second)},
+	} {
+		got, err := parseDate(c.value, c.loc, now)
+		require.NoError(t, err, c.value)
+		require.True(t, c.want.Equal(got), "%s in %s: want %s, got %s", c.value, c.loc, c.want, got)
+	}
+
+	for _, c := range []struct {
+		value string
+		msg   string
+	}{
+		{"nowish", `invalid relative date "nowish"`},
+		{"now-1y", `invalid relative date "now-1y"`},
+		{"now-d", `invalid relative date "now-d"`},
+		{"now+9223372037s", `relative date "now+9223372037s" is out of range`},
+		{"now+2562048h", `relative date "now+2562048h" is out of range`},
+		{"now-106752d", `relative date "now-106752d" is out of range`},
+		{"now-15251w", `relative date "now-15251w" is out of range`},
+		{"now-99999999999999999999h", `relative date "now-99999999999999999999h" is out of range`},
+		{"2016-13-01", `parsing time "2016-13-01": month out of range`},
+		{"02/01/2016", `parsing time "02/01/2016" as "2006-01-02": cannot parse "02/01/2016" as "2006"`},
+		{"2016-01-02T10:00:00", `parsing time "2016-01-02T10:00:00" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "" as "Z07:00"`},
+	} {
+		_, err := parseDate(c.value, time.UTC, now)
+		require.EqualError(t, err, c.msg)
+	}
+}
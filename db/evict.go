@@ -0,0 +1,268 @@
+package db
+
+import (
+	"container/list"
+	"errors"
+	"expvar"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/getircase/config"
+)
+
+// ErrStoreFull ...
+// Returned when a write would take the store over its cap and eviction is disabled
+var ErrStoreFull = errors.New("store is full")
+
+// metrics ...
+// expvar map "memdb" every store metric is published in
+var metrics = expvar.NewMap("memdb")
+
+// Eviction metrics published by expvar under "memdb"
+var (
+	metricEvictions    = new(expvar.Int)
+	metricEvictedBytes = new(expvar.Int)
+	metricRejected     = new(expvar.Int)
+	metricKeys         = new(expvar.Int)
+	metricBytes        = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("evictions", metricEvictions)
+	metrics.Set("evictedBytes", metricEvictedBytes)
+	metrics.Set("rejectedWrites", metricRejected)
+	metrics.Set("keys", metricKeys)
+	metrics.Set("bytes", metricBytes)
+}
+
+// tracked ...
+// Stored key known to the tracker with its key and value bytes and badger expiry
+type tracked struct {
+	key       string
+	size      int64
+	expiresAt uint64
+}
+
+// expired ...
+// Reports whether badger no longer serves the key
+func (t tracked) expired(now uint64) bool {
+	return t.expiresAt > 0 && t.expiresAt <= now
+}
+
+// tracker ...
+// Orders the stored keys from most to least recently used for eviction and counts the store size
+// writeMu runs capped writes one at a time, mu guards the order and totals
+// stale is set when keys changed outside of write, the next write recounts
+type tracker struct {
+	policy            string
+	maxKeys, maxBytes int64
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	order   *list.List
+	index   map[string]*list.Element
+	keys    int64
+	bytes   int64
+	stale   int32
+}
+
+// newTracker ...
+// Returns an empty tracker enforcing the cap of cfg
+func newTracker(cfg config.MemDB) *tracker {
+	return &tracker{
+		policy:   cfg.Eviction,
+		maxKeys:  cfg.MaxKeys,
+		maxBytes: cfg.MaxBytes,
+		order:    list.New(),
+		index:    map[string]*list.Element{},
+	}
+}
+
+// fits ...
+// Reports whether keys and bytes stay within the cap
+func (t *tracker) fits(keys, bytes int64) bool {
+	return (t.maxKeys == 0 || keys <= t.maxKeys) && (t.maxBytes == 0 || bytes <= t.maxBytes)
+}
+
+// invalidate ...
+// Marks the tracker out of date after a restore or a dropped namespace
+func (t *tracker) invalidate() {
+	atomic.StoreInt32(&t.stale, 1)
+}
+
+// rebuild ...
+// Reloads every stored key ordered by version, the newest counts as the most recently used
+func (t *tracker) rebuild(db *badger.DB) error {
+	type versioned struct {
+		tracked
+		version uint64
+	}
+	var all []versioned
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			all = append(all, versioned{
+				tracked: tracked{key: string(item.KeyCopy(nil)), size: int64(len(item.Key())) + item.ValueSize(), expiresAt: item.ExpiresAt()},
+				version: item.Version(),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].version < all[j].version })
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.order.Init()
+	t.index = make(map[string]*list.Element, len(all))
+	t.keys, t.bytes = 0, 0
+	for _, v := range all {
+		t.index[v.key] = t.order.PushFront(v.tracked)
+		t.keys++
+		t.bytes += v.size
+	}
+	atomic.StoreInt32(&t.stale, 0)
+	t.publish()
+	return nil
+}
+
+// touch ...
+// Marks the stored key as used by a read, only the lru policy cares
+func (m *memdb) touch(key []byte) {
+	t := m.tracker
+	if t == nil || t.policy != config.EvictionLRU {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.index[string(key)]; ok {
+		t.order.MoveToFront(e)
+	}
+}
+
+// makeRoom ...
+// Checks the store fits its cap once d is committed, evicting the least recently used keys in txn
+// Expired keys are forgotten without a delete, evicted keys are deleted and published to watchers
+// Keys written or deleted by d itself are never evicted
+// return ErrStoreFull when eviction is disabled or can not free enough room
+func (t *tracker) makeRoom(db *badger.DB, txn *badger.Txn, d *change) error {
+	if atomic.LoadInt32(&t.stale) == 1 {
+		if err := t.rebuild(db); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// final state of every key d touches, later writes of the same key win
+	final := map[string]*tracked{}
+	for i := range d.written {
+		final[d.written[i].key] = &d.written[i]
+	}
+	for _, key := range d.deleted {
+		final[key] = nil
+	}
+	keys, bytes := t.keys, t.bytes
+	for key, w := range final {
+		if e, ok := t.index[key]; ok {
+			keys--
+			bytes -= e.Value.(tracked).size
+		}
+		if w != nil {
+			keys++
+			bytes += w.size
+		}
+	}
+	if t.fits(keys, bytes) {
+		return nil
+	}
+	// expired keys are gone whether the write commits or not, they make room without evicting anything
+	now := uint64(time.Now().Unix())
+	if t.policy == config.EvictionNone {
+		for e := t.order.Back(); e != nil; {
+			prev := e.Prev()
+			v := e.Value.(tracked)
+			if _, ok := final[v.key]; !ok && v.expired(now) {
+				t.remove(v.key)
+				keys--
+				bytes -= v.size
+			}
+			e = prev
+		}
+		if t.fits(keys, bytes) {
+			return nil
+		}
+		return ErrStoreFull
+	}
+	for e := t.order.Back(); e != nil && !t.fits(keys, bytes); {
+		prev := e.Prev()
+		v := e.Value.(tracked)
+		if _, ok := final[v.key]; !ok {
+			if v.expired(now) {
+				t.remove(v.key)
+			} else {
+				if err := txn.Delete([]byte(v.key)); err != nil {
+					return err
+				}
+				d.evicted = append(d.evicted, v)
+			}
+			keys--
+			bytes -= v.size
+		}
+		e = prev
+	}
+	if !t.fits(keys, bytes) {
+		return ErrStoreFull
+	}
+	return nil
+}
+
+// apply ...
+// Records a committed change, written keys become the most recently used
+func (t *tracker) apply(d change) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range d.deleted {
+		t.remove(key)
+	}
+	for _, v := range d.evicted {
+		t.remove(v.key)
+		metricEvictions.Add(1)
+		metricEvictedBytes.Add(v.size)
+	}
+	for _, w := range d.written {
+		t.remove(w.key)
+		t.index[w.key] = t.order.PushFront(w)
+		t.keys++
+		t.bytes += w.size
+	}
+	t.publish()
+}
+
+// remove ...
+// Forgets key, the caller holds mu
+func (t *tracker) remove(key string) {
+	e, ok := t.index[key]
+	if !ok {
+		return
+	}
+	t.order.Remove(e)
+	delete(t.index, key)
+	t.keys--
+	t.bytes -= e.Value.(tracked).size
+}
+
+// publish ...
+// Exports the store size, the caller holds mu
+func (t *tracker) publish() {
+	metricKeys.Set(t.keys)
+	metricBytes.Set(t.bytes)
+}
@@ -0,0 +1,135 @@
+package db_test
+
+import (
+	"bytes"
+	"expvar"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/getircase/config"
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+// newCappedMemDB ...
+// Fresh in-memory store limited to maxKeys keys evicting by policy
+func newCappedMemDB(t *testing.T, maxKeys int64, policy string) db.MemDBManager {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.MaxKeys = maxKeys
+	cfg.Eviction = policy
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = mgr.Close() })
+	return mgr
+}
+
+// metric ...
+// Current value of an integer in the memdb expvar map
+func metric(t *testing.T, name string) int64 {
+	n, err := strconv.ParseInt(expvar.Get("memdb").(*expvar.Map).Get(name).String(), 10, 64)
+	require.NoError(t, err)
+	return n
+}
+
+func TestInMemDbEvictionNone(t *testing.T) {
+	mgr := newCappedMemDB(t, 2, config.EvictionNone)
+	rejected := metric(t, "rejectedWrites")
+	require.NoError(t, mgr.SetKV("a", "1"))
+	require.NoError(t, mgr.SetKV("b", "1"))
+	require.Equal(t, db.ErrStoreFull, mgr.SetKV("c", "1"))
+	require.Equal(t, rejected+1, metric(t, "rejectedWrites"))
+	// overwrites still fit, deletes free room
+	require.NoError(t, mgr.SetKV("a", "2"))
+	require.NoError(t, mgr.Delete("a"))
+	require.NoError(t, mgr.SetKV("c", "1"))
+	require.Equal(t, int64(2), metric(t, "keys"))
+	// expired keys make room
+	require.NoError(t, mgr.Expire("b", time.Second))
+	time.Sleep(2 * time.Second)
+	require.NoError(t, mgr.SetKV("d", "1"))
+}
+
+func TestInMemDbEvictionOldest(t *testing.T) {
+	mgr := newCappedMemDB(t, 2, config.EvictionOldest)
+	evictions := metric(t, "evictions")
+	require.NoError(t, mgr.SetKV("a", "1"))
+	require.NoError(t, mgr.SetKV("b", "1"))
+	// reads do not count, a is still the oldest write
+	_, err := mgr.Retrieve("a")
+	require.NoError(t, err)
+	require.NoError(t, mgr.SetKV("c", "1"))
+	found, err := mgr.Exists("a")
+	require.NoError(t, err)
+	require.False(t, found)
+	require.Equal(t, evictions+1, metric(t, "evictions"))
+	// a batch evicts as many keys as it needs but never its own
+	require.NoError(t, mgr.SetBatch([]models.InMemory{{Key: "d", Value: models.Text("1")}, {Key: "e", Value: models.Text("1")}}))
+	list, err := mgr.List("", 0, "", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"d", "e"}, list.Keys)
+	require.Equal(t, db.ErrStoreFull, mgr.SetBatch([]models.InMemory{{Key: "f", Value: models.Text("1")}, {Key: "g", Value: models.Text("1")}, {Key: "h", Value: models.Text("1")}}))
+	_, err = mgr.Incr("f", 1)
+	require.NoError(t, err)
+	list, err = mgr.List("", 0, "", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"e", "f"}, list.Keys)
+}
+
+func TestInMemDbEvictionLRU(t *testing.T) {
+	mgr := newCappedMemDB(t, 2, config.EvictionLRU)
+	require.NoError(t, mgr.SetKV("a", "1"))
+	require.NoError(t, mgr.SetKV("b", "1"))
+	// reading a makes b the least recently used
+	_, err := mgr.Retrieve("a")
+	require.NoError(t, err)
+	require.NoError(t, mgr.SetKV("c", "1"))
+	list, err := mgr.List("", 0, "", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, list.Keys)
+
+	// values larger than the byte cap never fit
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.MaxBytes = 8
+	cfg.Eviction = config.EvictionLRU
+	small, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer small.Close()
+	require.NoError(t, small.SetKV("k", "1234"))
+	require.Equal(t, db.ErrStoreFull, small.SetKV("big", "123456789"))
+	require.NoError(t, small.SetKV("j", "1234"))
+	_, err = small.Retrieve("k")
+	require.Equal(t, db.ErrKeyNotFound, err)
+}
+
+func TestInMemDbEvictionRestore(t *testing.T) {
+	// keys loaded from a backup count against the cap
+	src := newMemDB(t)
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, src.SetKV(k, "1"))
+	}
+	var buf bytes.Buffer
+	_, err := src.Backup(&buf, 0)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "memdb.bak")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.MaxKeys = 3
+	cfg.RestoreFrom = path
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+	require.Equal(t, db.ErrStoreFull, mgr.SetKV("d", "1"))
+	// a restore into the running store is recounted, the newer delete of a still wins
+	require.NoError(t, mgr.Delete("a"))
+	require.NoError(t, mgr.Load(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, mgr.SetKV("d", "1"))
+	require.Equal(t, db.ErrStoreFull, mgr.SetKV("e", "1"))
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/getircase/models"
+)
+
+// stampSize ...
+// Bytes of the write time prefixed to stamped values
+const stampSize = 8
+
+// stamp ...
+// Prefixes raw with the write time t as big endian unix nanoseconds
+func stamp(raw []byte, t time.Time) []byte {
+	out := make([]byte, stampSize, stampSize+len(raw))
+	binary.BigEndian.PutUint64(out, uint64(t.UnixNano()))
+	return append(out, raw...)
+}
+
+// unstamp ...
+// Write time and remaining bytes of stored bytes written with the given user meta
+// the time is zero for values written without one
+func unstamp(raw []byte, meta byte) (time.Time, []byte) {
+	if meta&metaStamped == 0 || len(raw) < stampSize {
+		return time.Time{}, raw
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(raw))), raw[stampSize:]
+}
+
+// plain ...
+// Plain bytes of stored bytes written with the given user meta, without write time and decompressed
+func plain(raw []byte, meta byte) ([]byte, error) {
+	_, raw = unstamp(raw, meta)
+	return inflate(raw, meta)
+}
+
+// versions ...
+// Calls fn with every version of the stored key k still kept by badger, newest first, till fn returns false
+// Deleted versions are included, their user meta lacks metaLive
+func (m *memdb) versions(k []byte, fn func(item *badger.Item) (bool, error)) error {
+	return m.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.AllVersions = true
+		opts.Prefix = k
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(k); it.Valid() && bytes.Equal(it.Item().Key(), k); it.Next() {
+			more, err := fn(it.Item())
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// History ...
+// Returns the versions of the key badger still keeps, newest first, at most NumVersionsToKeep of them
+// Versions written while the store keeps more than one carry their write time
+// return ErrKeyNotFound if no version is kept
+func (m *memdb) History(key string) (models.KeyHistory, error) {
+	out := models.KeyHistory{Key: key, Versions: []models.KeyVersion{}}
+	k, err := m.key(key)
+	if err != nil {
+		return out, err
+	}
+	keep := m.cfg.NumVersionsToKeep
+	if keep < 1 {
+		keep = 1
+	}
+	err = m.versions(k, func(item *badger.Item) (bool, error) {
+		v := models.KeyVersion{Version: models.Version(item.Version())}
+		if item.UserMeta()&metaLive == 0 {
+			v.Deleted = true
+		} else {
+			raw, err := item.ValueCopy(nil)
+			if err != nil {
+				return false, err
+			}
+			written, raw := unstamp(raw, item.UserMeta())
+			if !written.IsZero() {
+				v.Time = &written
+			}
+			if raw, err = inflate(raw, item.UserMeta()); err != nil {
+				return false, err
+			}
+			v.Value = decode(raw, item.UserMeta())
+			v.ContentType = blobType(raw, item.UserMeta())
+		}
+		out.Versions = append(out.Versions, v)
+		return len(out.Versions) < keep, nil
+	})
+	if err != nil {
+		return out, err
+	}
+	if len(out.Versions) == 0 {
+		return out, ErrKeyNotFound
+	}
+	return out, nil
+}
+
+// RetrieveAt ...
+// fetches the key as it was at version, the newest kept version not above it
+// Expired values are returned as they were stored, without ttl
+// return ErrKeyNotFound if the key did not exist at version or that version is no longer kept
+func (m *memdb) RetrieveAt(key string, version uint64) (models.InMemory, error) {
+	out := models.InMemory{Key: key}
+	k, err := m.key(key)
+	if err != nil {
+		return out, err
+	}
+	found := false
+	err = m.versions(k, func(item *badger.Item) (bool, error) {
+		if item.Version() > version {
+			return true, nil
+		}
+		if item.UserMeta()&metaLive == 0 {
+			return false, nil
+		}
+		raw, err := readValue(item)
+		if err != nil {
+			return false, err
+		}
+		out.Value = decode(raw, item.UserMeta())
+		out.ContentType = blobType(raw, item.UserMeta())
+		out.Version = models.Version(item.Version())
+		if item.ExpiresAt() > 0 {
+			out.TTL = models.TTL(remaining(item.ExpiresAt()))
+		}
+		found = true
+		return false, nil
+	})
+	if err != nil {
+		return out, err
+	}
+	if !found {
+		return out, ErrKeyNotFound
+	}
+	return out, nil
+}
@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getircase/config"
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemDbHistory(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.NumVersionsToKeep = 3
+	cfg.CompressAbove = 16
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+
+	start := time.Now()
+	require.NoError(t, mgr.SetKV("cfg", "v1"))
+	first, err := mgr.Retrieve("cfg")
+	require.NoError(t, err)
+	require.Equal(t, "v1", first.Value.String())
+	require.NoError(t, mgr.Set("cfg", models.Value(`{"feature":{"enabled":true,"name":"tabs"}}`), 0))
+	require.NoError(t, mgr.Delete("cfg"))
+	require.NoError(t, mgr.SetKVWithTTL("cfg", "v3", time.Hour))
+	require.NoError(t, mgr.Expire("cfg", 2*time.Hour))
+
+	// newest first, deletes included, at most NumVersionsToKeep
+	history, err := mgr.History("cfg")
+	require.NoError(t, err)
+	require.Equal(t, "cfg", history.Key)
+	require.Len(t, history.Versions, 3)
+	require.Equal(t, "v3", history.Versions[0].Value.String())
+	require.Equal(t, "v3", history.Versions[1].Value.String())
+	require.True(t, history.Versions[2].Deleted)
+	require.Empty(t, history.Versions[2].Value)
+	require.Greater(t, uint64(history.Versions[0].Version), uint64(history.Versions[1].Version))
+	require.NotNil(t, history.Versions[0].Time)
+	require.False(t, history.Versions[0].Time.Before(start.Truncate(time.Millisecond)))
+	out, err := mgr.Retrieve("cfg")
+	require.NoError(t, err)
+	require.Equal(t, "v3", out.Value.String())
+	require.InDelta(t, float64(2*time.Hour), float64(out.TTL), float64(2*time.Second))
+
+	// as of a version reads the newest version not above it
+	out, err = mgr.RetrieveAt("cfg", uint64(first.Version))
+	require.NoError(t, err)
+	require.Equal(t, "v1", out.Value.String())
+	out, err = mgr.RetrieveAt("cfg", uint64(first.Version)+1)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"feature":{"enabled":true,"name":"tabs"}}`, string(out.Value))
+	_, err = mgr.RetrieveAt("cfg", uint64(history.Versions[2].Version))
+	require.Equal(t, db.ErrKeyNotFound, err)
+	_, err = mgr.RetrieveAt("cfg", uint64(first.Version)-1)
+	require.Equal(t, db.ErrKeyNotFound, err)
+
+	// counters keep working on stamped values
+	require.NoError(t, mgr.SetKV("hits", "1"))
+	n, err := mgr.Incr("hits", 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+	history, err = mgr.History("hits")
+	require.NoError(t, err)
+	require.Equal(t, "3", history.Versions[0].Value.String())
+	require.Equal(t, "1", history.Versions[1].Value.String())
+
+	_, err = mgr.History("missing")
+	require.Equal(t, db.ErrKeyNotFound, err)
+}
+
+func TestInMemDbHistoryDisabled(t *testing.T) {
+	mgr := newMemDB(t)
+	require.NoError(t, mgr.SetKV("cfg", "v1"))
+	require.NoError(t, mgr.SetKV("cfg", "v2"))
+	history, err := mgr.History("cfg")
+	require.NoError(t, err)
+	require.Len(t, history.Versions, 1)
+	require.Equal(t, "v2", history.Versions[0].Value.String())
+	require.Nil(t, history.Versions[0].Time)
+}
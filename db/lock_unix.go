@@ -0,0 +1,29 @@
+//go:build !windows
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockStore ...
+// Takes the exclusive directory lock badger holds while the store in dir is open
+// return ErrStoreInUse when a running store holds it
+func lockStore(dir string) (func(), error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", dir, err)
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrStoreInUse
+		}
+		return nil, fmt.Errorf("lock %s: %v", dir, err)
+	}
+	// closing the directory releases the lock
+	return func() { _ = f.Close() }, nil
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lockStore ...
+// Creates the LOCK file badger keeps open, and deletes on close, while the store in dir is open
+// return ErrStoreInUse when a running store holds it
+func lockStore(dir string) (func(), error) {
+	path := filepath.Join(dir, "LOCK")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return nil, ErrStoreInUse
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %v", dir, err)
+	}
+	return func() {
+		_ = f.Close()
+		_ = os.Remove(path)
+	}, nil
+}
@@ -0,0 +1,49 @@
+package db_test
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/getircase/config"
+	"github.com/getircase/db"
+	"github.com/stretchr/testify/require"
+)
+
+// mongoMgr ...
+// Shared MongoDb manager, nil when no cluster is configured
+var mongoMgr db.MongoManager
+
+func TestMain(m *testing.M) {
+	// Mongo backed tests need GETIR_MONGO_URI (or GETIR_CONFIG) pointing at a reachable cluster
+	if cfg, err := config.Load("db.test", nil); err == nil {
+		if mongoMgr, err = db.NewMongoManager(cfg.Mongo); err != nil {
+			log.Printf("mongo tests disabled: %v", err)
+		}
+	}
+	code := m.Run()
+	if mongoMgr != nil {
+		_ = mongoMgr.Close()
+	}
+	os.Exit(code)
+}
+
+// requireMongo ...
+// Skips the test when no MongoDb cluster is configured
+func requireMongo(t *testing.T) db.MongoManager {
+	if mongoMgr == nil {
+		t.Skip("mongo not configured, set GETIR_MONGO_URI")
+	}
+	return mongoMgr
+}
+
+// newMemDB ...
+// Fresh in-memory store closed when the test ends
+func newMemDB(t *testing.T) db.MemDBManager {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = mgr.Close() })
+	return mgr
+}
@@ -1,81 +1,685 @@
 package db
 
 import (
-	"log"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	badger "github.com/dgraph-io/badger/v3"
+	"github.com/getircase/config"
+	"github.com/getircase/models"
+)
+
+// Errors returned by MemDBManager
+var (
+	// ErrKeyNotFound ...
+	// Returned when the requested key is not stored
+	ErrKeyNotFound = badger.ErrKeyNotFound
+	// ErrEmptyKey ...
+	// Returned when an empty key is used
+	ErrEmptyKey = badger.ErrEmptyKey
+	// ErrTxnTooBig ...
+	// Returned when a batch does not fit in a single transaction
+	ErrTxnTooBig = badger.ErrTxnTooBig
+	// ErrVersionMismatch ...
+	// Returned by a conditional write when the stored version is not the expected one
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrNotNumeric ...
+	// Returned when a counter operation finds a value that is not an integer
+	ErrNotNumeric = errors.New("value is not an integer")
+	// ErrOverflow ...
+	// Returned when a counter operation would leave the int64 range
+	ErrOverflow = errors.New("increment would overflow")
+	// ErrBadCursor ...
+	// Returned when a listing cursor was not issued for the requested prefix
+	ErrBadCursor = errors.New("invalid cursor")
+	// ErrReservedKey ...
+	// Returned when a key of the default namespace starts with the namespace marker byte 0x00
+	ErrReservedKey = errors.New("keys starting with a 0x00 byte are reserved")
+	// ErrBadNamespace ...
+	// Returned when a namespace name is not 1 to 64 letters, digits, '-', '_' or '.'
+	ErrBadNamespace = errors.New("namespace must be 1 to 64 letters, digits, '-', '_' or '.'")
+	// ErrQuotaExceeded ...
+	// Returned when a write would take a namespace over its key or byte quota
+	ErrQuotaExceeded = errors.New("namespace quota exceeded")
+	// ErrValueTooLarge ...
+	// Returned when a value is larger than the configured max value size
+	ErrValueTooLarge = errors.New("value too large")
 )
 
 // MemDBManager ...
-// Interface Pattern with following functions SetKV Retrieve
+// Interface Pattern with following functions SetKV SetKVWithTTL Set CompareAndSet Expire Retrieve Exists Delete List SetBatch GetBatch Incr Backup Load Watch
+// SetBlob RetrieveBlob History RetrieveAt Namespace Namespaces DropNamespace Close
+// Key operations act on the namespace of the manager, Backup and Load on the whole store
 type MemDBManager interface {
 	SetKV(key, value string) error
-	Retrieve(key string) (out interface{}, err error)
+	SetKVWithTTL(key, value string, ttl time.Duration) error
+	Set(key string, value models.Value, ttl time.Duration) error
+	CompareAndSet(key string, value models.Value, ttl time.Duration, expected uint64) error
+	Expire(key string, ttl time.Duration) error
+	Retrieve(key string) (models.InMemory, error)
+	Exists(key string) (bool, error)
+	Delete(key string) error
+	List(prefix string, limit int, cursor string, withValues bool) (models.KeyList, error)
+	SetBatch(items []models.InMemory) error
+	GetBatch(keys []string) (models.InMemoryBatch, error)
+	Incr(key string, delta int64) (int64, error)
+	Backup(w io.Writer, since uint64) (uint64, error)
+	Load(r io.Reader) error
+	Watch(ctx context.Context, prefix string, since uint64) (*Watcher, error)
+	SetBlob(key string, blob models.Blob, ttl time.Duration) error
+	RetrieveBlob(key string) (models.Blob, error)
+	History(key string) (models.KeyHistory, error)
+	RetrieveAt(key string, version uint64) (models.InMemory, error)
+	MaxValueSize() int64
+	Namespace(name string) (MemDBManager, error)
+	Namespaces() ([]string, error)
+	DropNamespace(name string) error
+	Close() error
+}
+
+// store ...
+// badger db and state shared by all namespaces
+// stop ends the background value log GC, if any
+// mu lets a restore run without concurrent transactions, as badger requires
+// loadMu keeps backups, which read their own snapshot, apart from a restore only
+// hub delivers the published writes to the watchers
+type store struct {
+	db     *badger.DB
+	mu     sync.RWMutex
+	loadMu sync.RWMutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	cfg     config.MemDB
+	usageMu sync.Mutex
+	usage   map[string]*usage
+	tracker *tracker
+	hub     *hub
 }
 
 // memdb ...
 // Unexported memdb object for not be misused
-// SingleTon Pattern
+// A namespace view of the store, every key is stored behind prefix
+// The default namespace has an empty name and prefix
 type memdb struct {
-	db *badger.DB
+	*store
+	ns     string
+	prefix []byte
 }
 
-// inMemInstance ...
+// NewMemDBManager ...
 // By default, Badger ensures all the data is persisted to the disk.When Badger is running in in-memory mode
 // All the data is stored in the memory. Reads and writes are much faster in in-memory mode,
 // but all the data stored in Badger will be lost in case of a crash or close.
-// To open badger in in-memory mode, set the InMemory option.
-func inMemInstance() MemDBManager {
+// In-memory is the default mode, disk mode opens (or reopens) the data directory
+// and schedules value log GC in the background.
+// A configured encryption key encrypts the tables and value log with AES.
+func NewMemDBManager(cfg config.MemDB) (MemDBManager, error) {
 	opt := badger.DefaultOptions("").WithInMemory(true)
+	if cfg.Mode == config.MemDBModeDisk {
+		opt = badger.DefaultOptions(cfg.Dir).WithSyncWrites(cfg.SyncWrites)
+	}
+	opt, err := withLogLevel(opt, cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cfg.Key()
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		opt = opt.WithEncryptionKey(key).WithEncryptionKeyRotationDuration(time.Duration(cfg.EncryptionKeyRotation))
+	}
+	opt = opt.WithIndexCacheSize(cfg.IndexCacheSize)
+	if cfg.NumVersionsToKeep > 1 {
+		opt = opt.WithNumVersionsToKeep(cfg.NumVersionsToKeep)
+	}
 	db, err := badger.Open(opt)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("badger open: %v", err)
+	}
+	for name := range cfg.Namespaces {
+		if !validNamespace(name) {
+			_ = db.Close()
+			return nil, fmt.Errorf("memdb namespace %q: %v", name, ErrBadNamespace)
+		}
+	}
+	m := &memdb{store: &store{db: db, stop: make(chan struct{}), cfg: cfg, usage: map[string]*usage{}}}
+	if cfg.RestoreFrom != "" {
+		if err = m.restoreFile(cfg.RestoreFrom); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	// the cap covers keys already stored by disk mode or the restore
+	if cfg.MaxKeys > 0 || cfg.MaxBytes > 0 {
+		m.tracker = newTracker(cfg)
+		if err = m.tracker.rebuild(db); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	if m.hub, err = startHub(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if cfg.Mode == config.MemDBModeDisk && cfg.GCInterval > 0 {
+		m.wg.Add(1)
+		go m.runGC(time.Duration(cfg.GCInterval), cfg.GCDiscardRatio)
+	}
+	return m, nil
+}
+
+// runGC ...
+// Reclaims value log space every interval until the manager is closed
+// RunValueLogGC rewrites at most one file per call, so it is repeated while it finds work
+func (m *store) runGC(interval time.Duration, discardRatio float64) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			for m.db.RunValueLogGC(discardRatio) == nil {
+			}
+		}
+	}
+}
+
+// withLogLevel ...
+// Applies the configured log level name to the badger options
+func withLogLevel(opt badger.Options, name string) (badger.Options, error) {
+	switch name {
+	case "debug":
+		return opt.WithLoggingLevel(badger.DEBUG), nil
+	case "", "info":
+		return opt.WithLoggingLevel(badger.INFO), nil
+	case "warning":
+		return opt.WithLoggingLevel(badger.WARNING), nil
+	case "error":
+		return opt.WithLoggingLevel(badger.ERROR), nil
+	}
+	return opt, fmt.Errorf("unknown badger log level %q", name)
+}
+
+// Close ...
+// Stops the background GC and releases the badger db, the manager can not be used afterwards
+// Closing a namespace view does nothing, the store stays open
+func (m *memdb) Close() error {
+	if m.ns != "" {
+		return nil
+	}
+	close(m.stop)
+	m.wg.Wait()
+	return m.db.Close()
+}
+
+// metaLive ...
+// badger user meta bit set on every entry memdb writes
+// subscribers only see user meta, so an entry without it is a delete
+const metaLive byte = 1 << 0
+
+// metaJSON ...
+// badger user meta bit of values stored as raw JSON documents (content type application/json)
+// values without it are strings stored unquoted, so counters and older data read as text
+const metaJSON byte = 1 << 1
+
+// metaBlob ...
+// badger user meta bit of raw values stored with their content type, see frameBlob
+const metaBlob byte = 1 << 2
+
+// metaZstd ...
+// badger user meta bit of values stored zstd compressed, the other bits describe the plain bytes
+const metaZstd byte = 1 << 3
+
+// metaStamped ...
+// badger user meta bit of values prefixed with their write time, see stamp
+const metaStamped byte = 1 << 4
+
+// newEntry ...
+// badger entry for a set of the stored key, carrying the memdb user meta
+func newEntry(key []byte, value []byte) *badger.Entry {
+	return badger.NewEntry(key, value).WithMeta(metaLive)
+}
+
+// encode ...
+// Stored bytes and user meta of a value
+func encode(v models.Value) ([]byte, byte) {
+	if v.IsText() {
+		return []byte(v.String()), metaLive
 	}
-	return &memdb{db: db}
+	return v, metaLive | metaJSON
+}
+
+// decode ...
+// Value of stored bytes written with the given user meta
+// raw values read as the base64 string of their bytes
+func decode(raw []byte, meta byte) models.Value {
+	if meta&metaBlob != 0 {
+		_, data := unframeBlob(raw)
+		return models.Text(base64.StdEncoding.EncodeToString(data))
+	}
+	if meta&metaJSON != 0 {
+		return models.Value(raw)
+	}
+	return models.Text(string(raw))
+}
+
+// MaxValueSize ...
+// Largest value in bytes the store accepts, 0 is unlimited
+func (m *memdb) MaxValueSize() int64 {
+	return m.cfg.MaxValueSize
+}
+
+// checkSize ...
+// return ErrValueTooLarge when n value bytes exceed the configured max value size
+func (m *memdb) checkSize(n int) error {
+	if max := m.cfg.MaxValueSize; max > 0 && int64(n) > max {
+		return ErrValueTooLarge
+	}
+	return nil
+}
 
+// key ...
+// Stored badger key of key in the namespace
+// return ErrEmptyKey for an empty key and ErrReservedKey for a key hiding in the namespaces of the default one
+func (m *memdb) key(key string) ([]byte, error) {
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+	if m.ns == "" && strings.HasPrefix(key, nsMarker) {
+		return nil, ErrReservedKey
+	}
+	return append(append(make([]byte, 0, len(m.prefix)+len(key)), m.prefix...), key...), nil
 }
 
-// Unexported object of the Interface
-var memdbmgr = inMemInstance()
+// update ...
+// badger read-write transaction, shut out while a restore is running
+func (m *store) update(fn func(txn *badger.Txn) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.db.Update(fn)
+}
 
-// MemDBMgr ...
-// Exported function to be consumed from anywhere in the project
-// Return the Interface instance to expose the underlying functionality
-func MemDBMgr() MemDBManager { return memdbmgr }
+// view ...
+// badger read-only transaction, shut out while a restore is running
+func (m *store) view(fn func(txn *badger.Txn) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.db.View(fn)
+}
 
 // SetKV ...
 // Set key and associated value for the badger`s in-memory db
 func (m *memdb) SetKV(key, value string) error {
-	err := m.db.Update(func(txn *badger.Txn) error {
-		e := badger.NewEntry([]byte(key), []byte(value))
-		err := txn.SetEntry(e)
+	return m.SetKVWithTTL(key, value, 0)
+}
+
+// SetKVWithTTL ...
+// Set key and associated string value which badger expires after ttl
+// zero ttl keeps the key forever
+func (m *memdb) SetKVWithTTL(key, value string, ttl time.Duration) error {
+	return m.Set(key, models.Text(value), ttl)
+}
+
+// Set ...
+// Set key and associated JSON value which badger expires after ttl
+// zero ttl keeps the key forever
+func (m *memdb) Set(key string, value models.Value, ttl time.Duration) error {
+	k, err := m.key(key)
+	if err != nil {
+		return err
+	}
+	raw, meta := encode(value)
+	if err = m.checkSize(len(raw)); err != nil {
+		return err
+	}
+	raw, meta = m.compress(raw, meta)
+	err = m.write(func(txn *badger.Txn, d *change) error {
+		e := newEntry(k, raw).WithMeta(meta)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+		err := m.setEntry(txn, d, e)
 		return err
 	})
 	return err
 }
 
+// CompareAndSet ...
+// Set key and associated value only when the stored version equals expected
+// expected 0 requires the key not to exist, versions are the badger commit versions reported by Retrieve
+// return ErrVersionMismatch when the key changed, including a concurrent commit detected by badger
+func (m *memdb) CompareAndSet(key string, value models.Value, ttl time.Duration, expected uint64) error {
+	k, err := m.key(key)
+	if err != nil {
+		return err
+	}
+	raw, meta := encode(value)
+	if err = m.checkSize(len(raw)); err != nil {
+		return err
+	}
+	raw, meta = m.compress(raw, meta)
+	err = m.write(func(txn *badger.Txn, d *change) error {
+		var current uint64
+		item, err := txn.Get(k)
+		switch err {
+		case nil:
+			current = item.Version()
+		case badger.ErrKeyNotFound:
+		default:
+			return err
+		}
+		if current != expected {
+			return ErrVersionMismatch
+		}
+		e := newEntry(k, raw).WithMeta(meta)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+		return m.setEntry(txn, d, e)
+	})
+	if err == badger.ErrConflict {
+		return ErrVersionMismatch
+	}
+	return err
+}
+
+// Expire ...
+// Rewrites the existing key so it expires ttl from now
+// zero ttl removes the expiry, return err if Key not found
+func (m *memdb) Expire(key string, ttl time.Duration) error {
+	k, err := m.key(key)
+	if err != nil {
+		return err
+	}
+	return m.write(func(txn *badger.Txn, d *change) error {
+		item, err := txn.Get(k)
+		if err != nil {
+			return err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		// the rewrite is stamped with its own write time
+		_, val = unstamp(val, item.UserMeta())
+		e := newEntry(k, val).WithMeta(item.UserMeta()&^metaStamped | metaLive)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+		return m.setEntry(txn, d, e)
+	})
+}
+
+// Exists ...
+// Reports whether the key is stored without copying its value
+func (m *memdb) Exists(key string) (bool, error) {
+	k, err := m.key(key)
+	if err == nil {
+		err = m.view(func(txn *badger.Txn) error {
+			_, err := txn.Get(k)
+			return err
+		})
+	}
+	if err == badger.ErrKeyNotFound || err == badger.ErrEmptyKey || err == ErrReservedKey {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete ...
+// Removes the key and its value
+// return ErrKeyNotFound if Key not found
+func (m *memdb) Delete(key string) error {
+	k, err := m.key(key)
+	if err != nil {
+		return err
+	}
+	return m.write(func(txn *badger.Txn, d *change) error {
+		item, err := txn.Get(k)
+		if err != nil {
+			return err
+		}
+		if d != nil {
+			d.keys--
+			d.bytes -= m.size(item)
+			d.deleted = append(d.deleted, string(k))
+		}
+		return txn.Delete(k)
+	})
+}
+
+// List ...
+// Walks the keys starting with prefix in byte order using a badger iterator
+// cursor is the opaque value returned with the previous page, empty for the first page
+// returns at most limit keys (all when limit is zero) and the cursor of the next page when more keys remain
+func (m *memdb) List(prefix string, limit int, cursor string, withValues bool) (models.KeyList, error) {
+	out := models.KeyList{Keys: []string{}}
+	if withValues {
+		out.Values = map[string]models.Value{}
+	}
+	if m.ns == "" && strings.HasPrefix(prefix, nsMarker) {
+		return out, ErrReservedKey
+	}
+	start := []byte(prefix)
+	if cursor != "" {
+		last, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || !bytes.HasPrefix(last, start) {
+			return out, ErrBadCursor
+		}
+		start = last
+	}
+	// the keys of named namespaces sort first, the default namespace starts after them
+	if m.ns == "" && len(start) == 0 {
+		start = []byte{nsMarker[0] + 1}
+	}
+	start = append(append([]byte{}, m.prefix...), start...)
+	err := m.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = append(append([]byte{}, m.prefix...), prefix...)
+		opts.PrefetchValues = withValues
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			// the cursor key itself was served on the previous page
+			if cursor != "" && bytes.Equal(item.Key(), start) {
+				continue
+			}
+			if limit > 0 && len(out.Keys) == limit {
+				out.Cursor = base64.RawURLEncoding.EncodeToString([]byte(out.Keys[limit-1]))
+				return nil
+			}
+			key := string(item.Key()[len(m.prefix):])
+			out.Keys = append(out.Keys, key)
+			if withValues {
+				val, err := readValue(item)
+				if err != nil {
+					return err
+				}
+				out.Values[key] = decode(val, item.UserMeta())
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// SetBatch ...
+// Writes all items in a single badger transaction, either every item is stored or none
+// return ErrTxnTooBig when the batch exceeds the transaction limits
+func (m *memdb) SetBatch(items []models.InMemory) error {
+	return m.write(func(txn *badger.Txn, d *change) error {
+		for _, in := range items {
+			k, err := m.key(in.Key)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", in.Key, err)
+			}
+			raw, meta := encode(in.Value)
+			if err := m.checkSize(len(raw)); err != nil {
+				return fmt.Errorf("key %q: %w", in.Key, err)
+			}
+			raw, meta = m.compress(raw, meta)
+			e := newEntry(k, raw).WithMeta(meta)
+			if in.TTL > 0 {
+				e = e.WithTTL(in.TTL.Duration())
+			}
+			if err := m.setEntry(txn, d, e); err != nil {
+				return fmt.Errorf("key %q: %w", in.Key, err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetBatch ...
+// Reads the keys from one consistent snapshot
+// keys not stored are reported in Missing instead of failing the batch
+func (m *memdb) GetBatch(keys []string) (models.InMemoryBatch, error) {
+	out := models.InMemoryBatch{Records: []models.InMemory{}, Missing: []string{}}
+	err := m.view(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			var item *badger.Item
+			k, err := m.key(key)
+			if err == nil {
+				item, err = txn.Get(k)
+			}
+			if err == badger.ErrKeyNotFound || err == badger.ErrEmptyKey || err == ErrReservedKey {
+				out.Missing = append(out.Missing, key)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			val, err := readValue(item)
+			if err != nil {
+				return err
+			}
+			m.touch(k)
+			rec := models.InMemory{Key: key, Value: decode(val, item.UserMeta()), ContentType: blobType(val, item.UserMeta()), Version: models.Version(item.Version())}
+			if item.ExpiresAt() > 0 {
+				rec.TTL = models.TTL(remaining(item.ExpiresAt()))
+			}
+			out.Records = append(out.Records, rec)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// incrRetries ...
+// Attempts of a counter update before a badger conflict is reported
+const incrRetries = 16
+
+// incrBackoff ...
+// Upper bound of the first pause between counter retries, grows with every attempt
+const incrBackoff = 500 * time.Microsecond
+
+// Incr ...
+// Adds delta to the integer stored under key in a single badger transaction
+// A missing key counts from 0 and is stored as a string, an existing expiry and value type are kept
+// Concurrent updates of the same key conflict in badger and are retried
+// return ErrNotNumeric if the stored value is not an integer
+func (m *memdb) Incr(key string, delta int64) (int64, error) {
+	var next int64
+	k, err := m.key(key)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < incrRetries; i++ {
+		err = m.write(func(txn *badger.Txn, d *change) error {
+			var current int64
+			var expiresAt uint64
+			meta := metaLive
+			item, err := txn.Get(k)
+			switch err {
+			case nil:
+				val, err := readValue(item)
+				if err != nil {
+					return err
+				}
+				if current, err = strconv.ParseInt(string(val), 10, 64); err != nil {
+					return ErrNotNumeric
+				}
+				expiresAt = item.ExpiresAt()
+				// the new number is stored plain
+				meta |= item.UserMeta() &^ (metaZstd | metaStamped)
+			case badger.ErrKeyNotFound:
+			default:
+				return err
+			}
+			if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+				return ErrOverflow
+			}
+			next = current + delta
+			// a JSON number stays a number, a string stays a string
+			e := newEntry(k, []byte(strconv.FormatInt(next, 10))).WithMeta(meta)
+			e.ExpiresAt = expiresAt
+			return m.setEntry(txn, d, e)
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+		// back off a random while so contending writers do not collide again
+		time.Sleep(time.Duration(rand.Int63n(int64(i+1) * int64(incrBackoff))))
+	}
+	return next, err
+}
+
 // Retrieve ...
 // fetches the associated data from In-memory badger DB for the requested key
+// The record carries the value, the version and the remaining ttl of expiring keys
 // return err if Key not found or Key is empty
-func (m *memdb) Retrieve(key string) (out interface{}, err error) {
-	var valCopy []byte
-	err = m.db.View(func(txn *badger.Txn) error {
-		item, e := txn.Get([]byte(key))
+func (m *memdb) Retrieve(key string) (models.InMemory, error) {
+	out := models.InMemory{Key: key}
+	k, err := m.key(key)
+	if err != nil {
+		return out, err
+	}
+	err = m.view(func(txn *badger.Txn) error {
+		item, e := txn.Get(k)
 		if e != nil {
 			return e
 		}
-		valCopy, e = item.ValueCopy(nil)
+		valCopy, e := readValue(item)
 		if e != nil {
 			return e
 		}
+		out.Value = decode(valCopy, item.UserMeta())
+		out.ContentType = blobType(valCopy, item.UserMeta())
+		out.Version = models.Version(item.Version())
+		// remaining time to live, only reported for expiring keys
+		if item.ExpiresAt() > 0 {
+			out.TTL = models.TTL(remaining(item.ExpiresAt()))
+		}
 		return nil
 	})
-	if err == badger.ErrKeyNotFound || err == badger.ErrEmptyKey {
-		return
-	}
-	rs := map[string]string{}
-	rs["key"] = key
-	rs["value"] = string(valCopy)
-	out = rs
-	return
+	if err != nil {
+		return out, err
+	}
+	m.touch(k)
+	return out, nil
+}
+
+// remaining ...
+// Time left until the badger expiresAt unix timestamp, rounded to seconds
+func remaining(expiresAt uint64) time.Duration {
+	d := time.Until(time.Unix(int64(expiresAt), 0)).Round(time.Second)
+	if d < 0 {
+		return 0
+	}
+	return d
 }
@@ -1,43 +1,259 @@
 package db_test
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/getircase/config"
 	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestInMemDbError(t *testing.T) {
+	mgr := newMemDB(t)
 	// no value provided in the value
-	err := db.MemDBMgr().SetKV("test", "")
+	err := mgr.SetKV("test", "")
 	require.NoError(t, err)
 	// Unknown key
 	// err no key found
-	_, err = db.MemDBMgr().Retrieve("test1")
+	_, err = mgr.Retrieve("test1")
 	require.NotEmpty(t, err)
 	require.Errorf(t, err, "Key not found")
 	// Set the value with key will be errored
-	err = db.MemDBMgr().SetKV("", "testValue")
+	err = mgr.SetKV("", "testValue")
 	require.NotEmpty(t, err)
 	require.Errorf(t, err, "Key cannot be empty")
 }
 
 func TestInMemDbSuccess(t *testing.T) {
+	mgr := newMemDB(t)
 	k, v := "test", "testValue"
-	var out interface{}
+	var out models.InMemory
 	// initialize the db with key value
-	err := db.MemDBMgr().SetKV(k, v)
+	err := mgr.SetKV(k, v)
 	require.NoError(t, err)
 	// Retrieve the associated data for the key.
-	out, err = db.MemDBMgr().Retrieve(k)
+	out, err = mgr.Retrieve(k)
 	require.Empty(t, err)
-	// type assertion
-	rs, ok := out.(map[string]string)
-	require.Equal(t, true, ok)
-	// incoming result should not be nil
-	require.NotNil(t, rs)
-	// incoming result map should contain the key
-	require.Equal(t, rs["key"], k)
-	// incoming result map should contain the associated data to the key
-	require.Equal(t, rs["value"], v)
+	// incoming result should contain the key
+	require.Equal(t, out.Key, k)
+	// incoming result should contain the associated data to the key
+	require.Equal(t, out.Value.String(), v)
+}
+
+func TestInMemDbTTL(t *testing.T) {
+	mgr := newMemDB(t)
+	// key without ttl reports no ttl
+	require.NoError(t, mgr.SetKV("forever", "v"))
+	out, err := mgr.Retrieve("forever")
+	require.NoError(t, err)
+	require.Zero(t, out.TTL)
+
+	// key with ttl reports the remaining time
+	require.NoError(t, mgr.SetKVWithTTL("session", "v", time.Hour))
+	out, err = mgr.Retrieve("session")
+	require.NoError(t, err)
+	ttl := time.Duration(out.TTL)
+	require.InDelta(t, float64(time.Hour), float64(ttl), float64(2*time.Second))
+
+	// extending moves the expiry, zero removes it
+	require.NoError(t, mgr.Expire("session", 2*time.Hour))
+	out, err = mgr.Retrieve("session")
+	require.NoError(t, err)
+	ttl = time.Duration(out.TTL)
+	require.InDelta(t, float64(2*time.Hour), float64(ttl), float64(2*time.Second))
+	require.NoError(t, mgr.Expire("session", 0))
+	out, err = mgr.Retrieve("session")
+	require.NoError(t, err)
+	require.Equal(t, "v", out.Value.String())
+	require.Zero(t, out.TTL)
+
+	// unknown key can not be refreshed
+	require.Error(t, mgr.Expire("unknown", time.Minute))
+
+	// expired key is gone
+	require.NoError(t, mgr.SetKVWithTTL("short", "v", time.Second))
+	time.Sleep(2 * time.Second)
+	_, err = mgr.Retrieve("short")
+	require.Error(t, err)
+}
+
+func TestInMemDbDelete(t *testing.T) {
+	mgr := newMemDB(t)
+	require.NoError(t, mgr.SetKV("gone", "v"))
+	found, err := mgr.Exists("gone")
+	require.NoError(t, err)
+	require.True(t, found)
+	// delete the key and it is no longer found
+	require.NoError(t, mgr.Delete("gone"))
+	found, err = mgr.Exists("gone")
+	require.NoError(t, err)
+	require.False(t, found)
+	_, err = mgr.Retrieve("gone")
+	require.Error(t, err)
+	// deleting twice reports the missing key
+	require.Equal(t, db.ErrKeyNotFound, mgr.Delete("gone"))
+	require.Equal(t, db.ErrEmptyKey, mgr.Delete(""))
+}
+
+func TestInMemDbList(t *testing.T) {
+	mgr := newMemDB(t)
+	for _, k := range []string{"team-a/1", "team-a/2", "team-a/3", "team-b/1", "other"} {
+		require.NoError(t, mgr.SetKV(k, "v-"+k))
+	}
+	// first page of two keys under the prefix
+	page, err := mgr.List("team-a/", 2, "", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"team-a/1", "team-a/2"}, page.Keys)
+	require.Nil(t, page.Values)
+	require.NotEmpty(t, page.Cursor)
+	// next page continues after the cursor and is the last one
+	page, err = mgr.List("team-a/", 2, page.Cursor, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"team-a/3"}, page.Keys)
+	require.Equal(t, "v-team-a/3", page.Values["team-a/3"].String())
+	require.Empty(t, page.Cursor)
+	// no prefix and no limit lists everything
+	page, err = mgr.List("", 0, "", false)
+	require.NoError(t, err)
+	require.Len(t, page.Keys, 5)
+	// cursor issued for another prefix is rejected
+	_, err = mgr.List("team-b/", 1, "bm9wZQ", false)
+	require.Equal(t, db.ErrBadCursor, err)
+}
+
+func TestInMemDbBatch(t *testing.T) {
+	mgr := newMemDB(t)
+	items := []models.InMemory{{Key: "a", Value: models.Text("1")}, {Key: "b", Value: models.Text("2"), TTL: models.TTL(time.Hour)}}
+	require.NoError(t, mgr.SetBatch(items))
+	out, err := mgr.GetBatch([]string{"b", "missing", "a"})
+	require.NoError(t, err)
+	require.Len(t, out.Records, 2)
+	require.Equal(t, "b", out.Records[0].Key)
+	require.Equal(t, "2", out.Records[0].Value.String())
+	require.NotZero(t, out.Records[0].TTL)
+	require.Equal(t, "a", out.Records[1].Key)
+	require.Equal(t, []string{"missing"}, out.Missing)
+
+	// one bad item aborts the whole batch
+	err = mgr.SetBatch([]models.InMemory{{Key: "c", Value: models.Text("3")}, {Key: "", Value: models.Text("4")}})
+	require.True(t, errors.Is(err, db.ErrEmptyKey))
+	found, err := mgr.Exists("c")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestInMemDbCompareAndSet(t *testing.T) {
+	mgr := newMemDB(t)
+	// expected version 0 only creates
+	require.NoError(t, mgr.CompareAndSet("cfg", models.Text("v1"), 0, 0))
+	require.Equal(t, db.ErrVersionMismatch, mgr.CompareAndSet("cfg", models.Text("v1b"), 0, 0))
+	out, err := mgr.Retrieve("cfg")
+	require.NoError(t, err)
+	version := uint64(out.Version)
+	require.NotZero(t, version)
+	// write against the current version succeeds once
+	require.NoError(t, mgr.CompareAndSet("cfg", models.Text("v2"), 0, version))
+	require.Equal(t, db.ErrVersionMismatch, mgr.CompareAndSet("cfg", models.Text("v3"), 0, version))
+	out, err = mgr.Retrieve("cfg")
+	require.NoError(t, err)
+	require.Equal(t, "v2", out.Value.String())
+	next := uint64(out.Version)
+	require.Greater(t, next, version)
+}
+
+func TestInMemDbJSONValue(t *testing.T) {
+	mgr := newMemDB(t)
+	var doc models.Value
+	require.NoError(t, json.Unmarshal([]byte(`{"feature": {"enabled": true}, "limits": [1, 2]}`), &doc))
+	require.NoError(t, mgr.Set("cfg", doc, 0))
+	out, err := mgr.Retrieve("cfg")
+	require.NoError(t, err)
+	require.False(t, out.Value.IsText())
+	require.JSONEq(t, `{"feature":{"enabled":true},"limits":[1,2]}`, string(out.Value))
+	// a JSON string holding JSON stays a string
+	require.NoError(t, mgr.SetKV("quoted", `{"a":1}`))
+	out, err = mgr.Retrieve("quoted")
+	require.NoError(t, err)
+	require.True(t, out.Value.IsText())
+	require.Equal(t, `{"a":1}`, out.Value.String())
+
+	// a JSON number counts up and stays a number
+	require.NoError(t, mgr.Set("hits", models.Value("41"), 0))
+	n, err := mgr.Incr("hits", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), n)
+	page, err := mgr.List("", 0, "", true)
+	require.NoError(t, err)
+	require.Equal(t, models.Value("42"), page.Values["hits"])
+	require.Equal(t, `"{\"a\":1}"`, string(page.Values["quoted"]))
+	_, err = mgr.Incr("cfg", 1)
+	require.Equal(t, db.ErrNotNumeric, err)
+}
+
+func TestInMemDbIncr(t *testing.T) {
+	mgr := newMemDB(t)
+	// concurrent increments are not lost
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				_, err := mgr.Incr("hits", 1)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := mgr.Incr("hits", -50)
+	require.NoError(t, err)
+	require.Equal(t, int64(150), n)
+
+	// expiry of the counter is kept
+	require.NoError(t, mgr.SetKVWithTTL("window", "10", time.Hour))
+	n, err = mgr.Incr("window", 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(15), n)
+	out, err := mgr.Retrieve("window")
+	require.NoError(t, err)
+	require.NotZero(t, out.TTL)
+
+	// non numeric and overflowing values are rejected
+	require.NoError(t, mgr.SetKV("name", "abc"))
+	_, err = mgr.Incr("name", 1)
+	require.Equal(t, db.ErrNotNumeric, err)
+	require.NoError(t, mgr.SetKV("big", strconv.FormatInt(math.MaxInt64, 10)))
+	_, err = mgr.Incr("big", 1)
+	require.Equal(t, db.ErrOverflow, err)
+}
+
+func TestInMemDbDiskMode(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.Mode = config.MemDBModeDisk
+	cfg.Dir = t.TempDir()
+	cfg.SyncWrites = true
+	cfg.GCInterval = config.Duration(10 * time.Millisecond)
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	require.NoError(t, mgr.SetKV("durable", "yes"))
+	// let the background GC run at least once
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, mgr.Close())
+
+	// reopening the directory brings the data back
+	mgr, err = db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+	out, err := mgr.Retrieve("durable")
+	require.NoError(t, err)
+	require.Equal(t, "yes", out.Value.String())
 }
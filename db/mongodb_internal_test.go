@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPageOf(t *testing.T) {
+	// defaults
+	p, err := pageOf(models.MongoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, recordsPage{limit: defaultRecordsLimit, sort: "createdAt", order: orderAsc}, p)
+
+	p, err = pageOf(models.MongoRequest{Limit: 10, Page: 3, Sort: "totalCount", Order: "desc"})
+	require.NoError(t, err)
+	require.Equal(t, recordsPage{limit: 10, skip: 20, sort: "totalCount", order: orderDesc}, p)
+	p, err = pageOf(models.MongoRequest{Limit: maxRecordsLimit, Page: 1})
+	require.NoError(t, err)
+	require.Equal(t, 0, p.skip)
+
+	cursor, err := recordsPage{sort: "key", order: orderAsc}.nextCursor(bson.M{"key": "abc", "_id": "1"})
+	require.NoError(t, err)
+	for _, c := range []struct {
+		req models.MongoRequest
+		msg string
+	}{
+		{models.MongoRequest{Limit: -1}, "limit must be between 1 and 1000"},
+		{models.MongoRequest{Limit: maxRecordsLimit + 1}, "limit must be between 1 and 1000"},
+		{models.MongoRequest{Sort: "counts"}, "sort must be createdAt, totalCount or key"},
+		{models.MongoRequest{Order: "up"}, "order must be asc or desc"},
+		{models.MongoRequest{Page: -1}, "page must be positive"},
+		{models.MongoRequest{Page: math.MaxInt}, "page is too large"},
+		{models.MongoRequest{Limit: maxRecordsLimit, Page: math.MaxInt/maxRecordsLimit + 2}, "page is too large"},
+		{models.MongoRequest{Page: 2, Cursor: cursor, Sort: "key"}, "page and cursor can not be combined"},
+		{models.MongoRequest{Cursor: "not a cursor"}, "invalid cursor"},
+		{models.MongoRequest{Cursor: "AAAA"}, "invalid cursor"},
+		// a cursor only continues the ordering it was issued for
+		{models.MongoRequest{Cursor: cursor, Sort: "key", Order: "desc"}, "invalid cursor"},
+		{models.MongoRequest{Cursor: cursor}, "invalid cursor"},
+	} {
+		_, err := pageOf(c.req)
+		require.EqualError(t, err, c.msg, "%+v", c.req)
+	}
+
+	// the largest page that fits is accepted
+	p, err = pageOf(models.MongoRequest{Limit: maxRecordsLimit, Page: math.MaxInt/maxRecordsLimit + 1})
+	require.NoError(t, err)
+	require.Greater(t, p.skip, 0)
+}
+
+func TestRecordsPageStages(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		page  recordsPage
+		fetch int
+		want  []bson.M
+	}{
+		{"first page", recordsPage{limit: 10, sort: "createdAt", order: orderAsc}, 11, []bson.M{
+			{"$sort": bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+			{"$limit": 11},
+		}},
+		{"numbered page", recordsPage{limit: 10, skip: 20, sort: "totalCount", order: orderDesc}, 11, []bson.M{
+			{"$sort": bson.D{{Key: "totalCount", Value: -1}, {Key: "_id", Value: -1}}},
+			{"$skip": 20},
+			{"$limit": 11},
+		}},
+		{"everything", recordsPage{limit: 10, sort: "key", order: orderAsc}, 0, []bson.M{
+			{"$sort": bson.D{{Key: "key", Value: 1}, {Key: "_id", Value: 1}}},
+		}},
+		// ties on the sort field continue after the _id of the last record
+		{"keyset asc", recordsPage{limit: 10, sort: "totalCount", order: orderAsc, after: &recordsCursor{Value: int32(5), ID: "9"}}, 11, []bson.M{
+			{"$match": bson.M{"$or": bson.A{
+				bson.M{"totalCount": bson.M{"$gt": int32(5)}},
+				bson.M{"totalCount": int32(5), "_id": bson.M{"$gt": "9"}},
+			}}},
+			{"$sort": bson.D{{Key: "totalCount", Value: 1}, {Key: "_id", Value: 1}}},
+			{"$limit": 11},
+		}},
+		{"keyset desc", recordsPage{limit: 10, sort: "key", order: orderDesc, after: &recordsCursor{Value: "k", ID: "9"}}, 11, []bson.M{
+			{"$match": bson.M{"$or": bson.A{
+				bson.M{"key": bson.M{"$lt": "k"}},
+				bson.M{"key": "k", "_id": bson.M{"$lt": "9"}},
+			}}},
+			{"$sort": bson.D{{Key: "key", Value: -1}, {Key: "_id", Value: -1}}},
+			{"$limit": 11},
+		}},
+	} {
+		require.Equal(t, c.want, c.page.stages(c.fetch), c.name)
+	}
+}
+
+func TestRecordsCursor(t *testing.T) {
+	// the cursor of the last record carries its sort value and _id with their bson types
+	id := primitive.NewObjectID()
+	createdAt := primitive.NewDateTimeFromTime(primitive.NewObjectID().Timestamp())
+	page := recordsPage{limit: 2, sort: "createdAt", order: orderDesc}
+	cursor, err := page.nextCursor(bson.M{"_id": id, "createdAt": createdAt, "key": "abc"})
+	require.NoError(t, err)
+
+	next, err := pageOf(models.MongoRequest{Limit: 2, Cursor: cursor, Order: "desc"})
+	require.NoError(t, err)
+	require.Equal(t, &recordsCursor{Sort: "createdAt", Order: orderDesc, Value: createdAt, ID: id}, next.after)
+	require.Equal(t, bson.M{"$match": bson.M{"$or": bson.A{
+		bson.M{"createdAt": bson.M{"$lt": createdAt}},
+		bson.M{"createdAt": createdAt, "_id": bson.M{"$lt": id}},
+	}}}, next.stages(3)[0])
+}
+
+func TestQuery(t *testing.T) {
+	min, max := 2900.0, 3000.0
+	sd := time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)
+	ed := time.Date(2016, 6, 2, 0, 0, 0, 0, time.UTC)
+	project := bson.M{"$project": bson.M{"key": 1, "createdAt": 1, "totalCount": bson.M{"$sum": "$counts"}}}
+	for _, c := range []struct {
+		req      models.MongoRequest
+		pipeline []bson.M
+	}{
+		// bounds are exclusive by default
+		{models.MongoRequest{StartDate: "2016-01-02", EndDate: "2016-06-02", MinCount: &min, MaxCount: &max}, []bson.M{
+			{"$match": bson.M{"createdAt": bson.M{"$gt": sd, "$lt": ed}}},
+			project,
+			{"$match": bson.M{"totalCount": bson.M{"$gt": min, "$lt": max}}},
+		}},
+		{models.MongoRequest{StartDate: "2016-01-02", EndDate: "2016-06-02", MinCount: &min, MaxCount: &max,
+			StartInclusive: true, EndInclusive: true, MinInclusive: true, MaxInclusive: true}, []bson.M{
+			{"$match": bson.M{"createdAt": bson.M{"$gte": sd, "$lte": ed}}},
+			project,
+			{"$match": bson.M{"totalCount": bson.M{"$gte": min, "$lte": max}}},
+		}},
+		// omitted bounds are left out, a stage without bounds too
+		{models.MongoRequest{StartDate: "2016-01-02", MaxCount: &max, MaxInclusive: true}, []bson.M{
+			{"$match": bson.M{"createdAt": bson.M{"$gt": sd}}},
+			project,
+			{"$match": bson.M{"totalCount": bson.M{"$lte": max}}},
+		}},
+		{models.MongoRequest{EndDate: "2016-06-02", EndInclusive: true}, []bson.M{
+			{"$match": bson.M{"createdAt": bson.M{"$lte": ed}}},
+			project,
+		}},
+		{models.MongoRequest{MinCount: &min}, []bson.M{
+			project,
+			{"$match": bson.M{"totalCount": bson.M{"$gt": min}}},
+		}},
+		{models.MongoRequest{}, []bson.M{project}},
+		// equal bounds are a valid range
+		{models.MongoRequest{StartDate: "2016-01-02", EndDate: "2016-01-02", MinCount: &min, MaxCount: &min}, []bson.M{
+			{"$match": bson.M{"createdAt": bson.M{"$gt": sd, "$lt": sd}}},
+			project,
+			{"$match": bson.M{"totalCount": bson.M{"$gt": min, "$lt": min}}},
+		}},
+	} {
+		pipeline, _, err := query(c.req)
+		require.NoError(t, err)
+		require.Equal(t, c.pipeline, pipeline)
+	}
+
+	for _, c := range []struct {
+		req models.MongoRequest
+		msg string
+	}{
+		{models.MongoRequest{MinCount: &max, MaxCount: &min}, "minCount must not be greater than maxCount"},
+		{models.MongoRequest{StartDate: "2016-06-02", EndDate: "2016-01-02"}, "startDate must not be after endDate"},
+		{models.MongoRequest{StartDate: "2016-01-02", EndDate: "now-1000w"}, "startDate must not be after endDate"},
+		{models.MongoRequest{Timezone: "Mars/Olympus"}, `unknown timezone "Mars/Olympus"`},
+		{models.MongoRequest{Limit: -1}, "limit must be between 1 and 1000"},
+	} {
+		_, _, err := query(c.req)
+		require.EqualError(t, err, c.msg)
+	}
+}
+
+func TestRetrieveInvalidRequest(t *testing.T) {
+	min, max := 2900.0, 3000.0
+	// the request is refused before the collection is queried
+	m := &mongodb{}
+	for _, req := range []models.MongoRequest{
+		{MinCount: &max, MaxCount: &min},
+		{StartDate: "2016-06-02", EndDate: "2016-01-02"},
+		{Limit: maxRecordsLimit + 1},
+		{Cursor: "not a cursor"},
+		{StartDate: "2016-01-02", Timezone: "Mars/Olympus"},
+		{StartDate: "now-2562048h"},
+		{EndDate: "now+106752d"},
+	} {
+		out, err := m.Retrieve(req)
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, out.(models.MongoResponse).Code)
+		out, err = m.Stream(context.Background(), req, func(record bson.M) error { return nil })
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, out.(models.MongoResponse).Code)
+	}
+}
@@ -1,51 +1,74 @@
 package db_test
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"testing"
 
-	"github.com/getircase/db"
 	"github.com/getircase/models"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
+// count ...
+// Pointer to a count bound of a request
+func count(v float64) *float64 {
+	return &v
+}
+
 func TestMongoDbError(t *testing.T) {
+	mgr := requireMongo(t)
 	var req models.MongoRequest
 	var resp models.MongoResponse
 	req.EndDate = "2016-03-02"
-	req.MinCount = 2900
-	req.MaxCount = 3000
+	req.MinCount = count(2900)
+	req.MaxCount = count(3000)
 	// start date parse error Month out of range
 	req.StartDate = "2016-13-26"
-	_, err := db.MongoMgr().Retrieve(req)
+	_, err := mgr.Retrieve(req)
 	require.NotEmpty(t, err)
 	require.Errorf(t, err, "parsing time \"2016-13-26\": month out of range")
 	req.StartDate = "2016-01-32"
 	// start date parse error day out of range
-	_, err = db.MongoMgr().Retrieve(req)
+	_, err = mgr.Retrieve(req)
 	require.NotEmpty(t, err)
 	require.Errorf(t, err, "parsing time \"2016-01-32\": day out of range")
 
 	// end date parse error Month out of range
 	req.EndDate = "2018-13-26"
 	req.StartDate = "2016-01-26"
-	_, err = db.MongoMgr().Retrieve(req)
+	_, err = mgr.Retrieve(req)
 	require.NotEmpty(t, err)
 	require.Errorf(t, err, "parsing time \"2018-13-26\": month out of range")
 
 	// end date parse error day out of range
 	req.EndDate = "2018-01-32"
 	req.StartDate = "2016-01-26"
-	_, err = db.MongoMgr().Retrieve(req)
+	_, err = mgr.Retrieve(req)
 	require.NotEmpty(t, err)
 	require.Errorf(t, err, "parsing time \"2018-13-26\": month out of range")
 
-	// no data found error
-	// min greater than max
+	// min greater than max is refused up front
 	req.StartDate = "2016-01-02"
 	req.EndDate = "2016-03-02"
-	req.MinCount = 3100
-	req.MaxCount = 3000
-	rs, err := db.MongoMgr().Retrieve(req)
+	req.MinCount = count(3100)
+	req.MaxCount = count(3000)
+	rs, err := mgr.Retrieve(req)
+	require.EqualError(t, err, "minCount must not be greater than maxCount")
+	require.Equal(t, http.StatusBadRequest, rs.(models.MongoResponse).Code)
+
+	// start after end is refused up front
+	req.MinCount = count(2900)
+	req.StartDate = "2016-03-03"
+	_, err = mgr.Retrieve(req)
+	require.EqualError(t, err, "startDate must not be after endDate")
+
+	// no data found error
+	// no records in the date range
+	req.StartDate = "2030-01-02"
+	req.EndDate = "2030-03-02"
+	rs, err = mgr.Retrieve(req)
 	// err not empty
 	require.NotEmpty(t, err)
 	// no data found error message
@@ -61,13 +84,14 @@ func TestMongoDbError(t *testing.T) {
 }
 
 func TestMongoDb(t *testing.T) {
+	mgr := requireMongo(t)
 	var req models.MongoRequest
 	var resp models.MongoResponse
 	req.StartDate = "2016-01-02"
 	req.EndDate = "2016-03-02"
-	req.MinCount = 2900
-	req.MaxCount = 3000
-	rs, err := db.MongoMgr().Retrieve(req)
+	req.MinCount = count(2900)
+	req.MaxCount = count(3000)
+	rs, err := mgr.Retrieve(req)
 	// no error message
 	require.Empty(t, err)
 	require.NotNil(t, rs)
@@ -82,3 +106,202 @@ func TestMongoDb(t *testing.T) {
 	require.LessOrEqual(t, 0, len(resp.Records))
 
 }
+
+func TestMongoDbPagingError(t *testing.T) {
+	mgr := requireMongo(t)
+	req := models.MongoRequest{StartDate: "2016-01-02", EndDate: "2016-03-02", MinCount: count(2900), MaxCount: count(3000)}
+	for _, c := range []struct {
+		edit func(r *models.MongoRequest)
+		msg  string
+	}{
+		{func(r *models.MongoRequest) { r.Limit = -1 }, "limit must be between 1 and 1000"},
+		{func(r *models.MongoRequest) { r.Limit = 1001 }, "limit must be between 1 and 1000"},
+		{func(r *models.MongoRequest) { r.Sort = "counts" }, "sort must be createdAt, totalCount or key"},
+		{func(r *models.MongoRequest) { r.Order = "up" }, "order must be asc or desc"},
+		{func(r *models.MongoRequest) { r.Page = -1 }, "page must be positive"},
+		{func(r *models.MongoRequest) { r.Page, r.Cursor = 2, "abc" }, "page and cursor can not be combined"},
+		{func(r *models.MongoRequest) { r.Cursor = "not a cursor" }, "invalid cursor"},
+	} {
+		r := req
+		c.edit(&r)
+		rs, err := mgr.Retrieve(r)
+		require.EqualError(t, err, c.msg)
+		resp := rs.(models.MongoResponse)
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+		require.Equal(t, c.msg, resp.Msg)
+	}
+}
+
+func TestMongoDbPaging(t *testing.T) {
+	mgr := requireMongo(t)
+	req := models.MongoRequest{StartDate: "2016-01-02", EndDate: "2016-03-02", MinCount: count(2900), MaxCount: count(3000), Sort: "key", Order: "desc"}
+	rs, err := mgr.Retrieve(req)
+	require.NoError(t, err)
+	all := rs.(models.MongoResponse)
+	require.Equal(t, int64(len(all.Records)), all.Total)
+	require.Empty(t, all.NextCursor)
+	if len(all.Records) < 3 {
+		t.Skip("not enough records to page")
+	}
+	for i := 1; i < len(all.Records); i++ {
+		require.GreaterOrEqual(t, all.Records[i-1]["key"], all.Records[i]["key"])
+	}
+
+	// keyset pages of 2 walk the same records in the same order
+	req.Limit = 2
+	var keys []interface{}
+	for {
+		rs, err = mgr.Retrieve(req)
+		require.NoError(t, err)
+		resp := rs.(models.MongoResponse)
+		require.Equal(t, all.Total, resp.Total)
+		require.LessOrEqual(t, len(resp.Records), 2)
+		for _, record := range resp.Records {
+			require.NotContains(t, record, "_id")
+			keys = append(keys, record["key"])
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		req.Cursor = resp.NextCursor
+	}
+	require.Len(t, keys, len(all.Records))
+	for i, record := range all.Records {
+		require.Equal(t, record["key"], keys[i])
+	}
+
+	// the second numbered page starts with the third record
+	req.Cursor, req.Page = "", 2
+	rs, err = mgr.Retrieve(req)
+	require.NoError(t, err)
+	require.Equal(t, all.Records[2]["key"], rs.(models.MongoResponse).Records[0]["key"])
+
+	// a cursor only continues the ordering it was issued for
+	req.Page = 0
+	rs, _ = mgr.Retrieve(req)
+	req.Cursor, req.Order = rs.(models.MongoResponse).NextCursor, "asc"
+	_, err = mgr.Retrieve(req)
+	require.EqualError(t, err, "invalid cursor")
+}
+
+func TestMongoDbStream(t *testing.T) {
+	mgr := requireMongo(t)
+	req := models.MongoRequest{StartDate: "2016-01-02", EndDate: "2016-03-02", MinCount: count(2900), MaxCount: count(3000), Sort: "key"}
+	rs, err := mgr.Retrieve(req)
+	require.NoError(t, err)
+	want := rs.(models.MongoResponse).Records
+
+	// the stream hands over the same records in the same order
+	var got []bson.M
+	rs, err = mgr.Stream(context.Background(), req, func(record bson.M) error {
+		got = append(got, record)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Success", rs.(models.MongoResponse).Msg)
+	require.Equal(t, len(want), len(got))
+	for i := range want {
+		require.Equal(t, want[i]["key"], got[i]["key"])
+		require.NotContains(t, got[i], "_id")
+	}
+
+	// an error of the callback stops the stream
+	stop := errors.New("stop")
+	calls := 0
+	_, err = mgr.Stream(context.Background(), req, func(record bson.M) error {
+		calls++
+		return stop
+	})
+	require.True(t, errors.Is(err, stop))
+	require.Equal(t, 1, calls)
+
+	// no records answers like Retrieve
+	req.StartDate, req.EndDate = "2030-01-02", "2030-03-02"
+	rs, err = mgr.Stream(context.Background(), req, func(record bson.M) error { return nil })
+	require.EqualError(t, err, "no data found")
+	require.Equal(t, http.StatusNoContent, rs.(models.MongoResponse).Code)
+}
+
+func TestMongoDbBounds(t *testing.T) {
+	mgr := requireMongo(t)
+	req := models.MongoRequest{StartDate: "2016-01-02", EndDate: "2016-03-02", MinCount: count(2900), MaxCount: count(3000)}
+	rs, err := mgr.Retrieve(req)
+	require.NoError(t, err)
+	records := rs.(models.MongoResponse).Records
+	if len(records) == 0 {
+		t.Skip("no records to bound")
+	}
+	total := records[0]["totalCount"]
+	var n float64
+	switch v := total.(type) {
+	case int32:
+		n = float64(v)
+	case int64:
+		n = float64(v)
+	case float64:
+		n = v
+	}
+
+	// a record exactly on both bounds is only found with inclusive bounds
+	req.MinCount, req.MaxCount = count(n), count(n)
+	_, err = mgr.Retrieve(req)
+	require.EqualError(t, err, "no data found")
+	req.MinInclusive, req.MaxInclusive = true, true
+	rs, err = mgr.Retrieve(req)
+	require.NoError(t, err)
+	for _, record := range rs.(models.MongoResponse).Records {
+		require.EqualValues(t, total, record["totalCount"])
+	}
+
+	// omitted bounds leave the range open
+	req = models.MongoRequest{StartDate: "2016-01-02", MinCount: count(2900), Limit: 1}
+	rs, err = mgr.Retrieve(req)
+	require.NoError(t, err)
+	open := rs.(models.MongoResponse).Total
+	require.GreaterOrEqual(t, open, int64(len(records)))
+	rs, err = mgr.Retrieve(models.MongoRequest{Limit: 1})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, rs.(models.MongoResponse).Total, open)
+}
+
+func TestMongoDbDates(t *testing.T) {
+	mgr := requireMongo(t)
+	req := models.MongoRequest{StartDate: "2016-01-02", EndDate: "2016-03-02", MinCount: count(2900), MaxCount: count(3000), Limit: 1}
+	rs, err := mgr.Retrieve(req)
+	require.NoError(t, err)
+	want := rs.(models.MongoResponse).Total
+
+	// RFC 3339 timestamps of the same instants find the same records
+	req.StartDate, req.EndDate = "2016-01-02T03:00:00+03:00", "2016-03-02T00:00:00Z"
+	rs, err = mgr.Retrieve(req)
+	require.NoError(t, err)
+	require.Equal(t, want, rs.(models.MongoResponse).Total)
+
+	// calendar dates are midnight in the timezone, three hours earlier in Istanbul
+	req.StartDate, req.EndDate, req.Timezone = "2016-01-02", "2016-03-02", "Europe/Istanbul"
+	rs, _ = mgr.Retrieve(req)
+	got := rs.(models.MongoResponse).Total
+	req.StartDate, req.EndDate, req.Timezone = "2016-01-01T22:00:00Z", "2016-03-01T22:00:00Z", ""
+	rs, _ = mgr.Retrieve(req)
+	require.Equal(t, rs.(models.MongoResponse).Total, got)
+
+	// relative dates count from now, nothing was created in the future
+	req = models.MongoRequest{StartDate: "now+1d"}
+	_, err = mgr.Retrieve(req)
+	require.EqualError(t, err, "no data found")
+
+	for _, c := range []struct {
+		req models.MongoRequest
+		msg string
+	}{
+		{models.MongoRequest{StartDate: "2016-13-26"}, "parsing time \"2016-13-26\": month out of range"},
+		{models.MongoRequest{EndDate: "2016-01-02T25:00:00Z"}, "parsing time \"2016-01-02T25:00:00Z\": hour out of range"},
+		{models.MongoRequest{StartDate: "now-7x"}, "invalid relative date \"now-7x\""},
+		{models.MongoRequest{StartDate: "2016-01-02", Timezone: "Mars/Base"}, "unknown timezone \"Mars/Base\""},
+		{models.MongoRequest{StartDate: "now", EndDate: "now-1d"}, "startDate must not be after endDate"},
+	} {
+		rs, err := mgr.Retrieve(c.req)
+		require.EqualError(t, err, c.msg)
+		require.Equal(t, http.StatusBadRequest, rs.(models.MongoResponse).Code)
+	}
+}
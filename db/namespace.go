@@ -0,0 +1,258 @@
+package db
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// nsMarker ...
+// First byte of every key stored in a named namespace
+// keys are stored as marker, name, marker, key so they never collide with the default namespace
+const nsMarker = "\x00"
+
+// namespaceName ...
+// Allowed namespace names, they never contain the marker byte
+var namespaceName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// validNamespace ...
+// Reports whether name can be used as a namespace
+func validNamespace(name string) bool {
+	return namespaceName.MatchString(name)
+}
+
+// nsPrefix ...
+// Stored key prefix of the named namespace
+func nsPrefix(name string) []byte {
+	return []byte(nsMarker + name + nsMarker)
+}
+
+// Namespace ...
+// Returns the manager of the named namespace sharing this store
+// An empty name returns the default namespace
+// return ErrBadNamespace for names outside [A-Za-z0-9_.-]{1,64}
+func (m *memdb) Namespace(name string) (MemDBManager, error) {
+	if name == "" {
+		return &memdb{store: m.store}, nil
+	}
+	if !validNamespace(name) {
+		return nil, ErrBadNamespace
+	}
+	return &memdb{store: m.store, ns: name, prefix: nsPrefix(name)}, nil
+}
+
+// Namespaces ...
+// Lists the named namespaces holding at least one key, in byte order
+func (m *memdb) Namespaces() ([]string, error) {
+	names := []string{}
+	err := m.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(nsMarker)
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); {
+			key := it.Item().Key()
+			end := len(nsMarker)
+			for end < len(key) && key[end] != nsMarker[0] {
+				end++
+			}
+			name := string(key[len(nsMarker):end])
+			names = append(names, name)
+			// skip the remaining keys of the namespace, 0x01 sorts below every name byte
+			it.Seek([]byte(nsMarker + name + "\x01"))
+		}
+		return nil
+	})
+	return names, err
+}
+
+// DropNamespace ...
+// Removes every key of the named namespace with badger DropPrefix
+// Watchers are not told about the dropped keys
+// Other transactions wait until the drop completes
+func (m *memdb) DropNamespace(name string) error {
+	if !validNamespace(name) {
+		return ErrBadNamespace
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.DropPrefix(nsPrefix(name)); err != nil {
+		return err
+	}
+	m.resetUsage(name)
+	if m.tracker != nil {
+		m.tracker.invalidate()
+	}
+	return nil
+}
+
+// change ...
+// Effect of a write transaction
+// keys and bytes count the namespace usage, the lists name the stored keys for the store tracker
+type change struct {
+	keys, bytes int64
+	written     []tracked
+	deleted     []string
+	evicted     []tracked
+}
+
+// usage ...
+// Counted keys and bytes of a namespace with a quota
+// Writes to the namespace hold mu, known is false until the namespace was counted
+// Expired keys stay counted till the next recount, so the totals only ever overestimate
+type usage struct {
+	mu    sync.Mutex
+	known bool
+	total change
+}
+
+// exceeds ...
+// Reports whether applying d takes the namespace over maxKeys or maxBytes, 0 is unlimited
+// Writes that free space are always allowed
+func (u *usage) exceeds(d change, maxKeys, maxBytes int64) bool {
+	if d.keys > 0 && maxKeys > 0 && u.total.keys+d.keys > maxKeys {
+		return true
+	}
+	return d.bytes > 0 && maxBytes > 0 && u.total.bytes+d.bytes > maxBytes
+}
+
+// usageOf ...
+// Usage tracker of the named namespace, created on first use
+func (m *store) usageOf(name string) *usage {
+	m.usageMu.Lock()
+	defer m.usageMu.Unlock()
+	u, ok := m.usage[name]
+	if !ok {
+		u = &usage{}
+		m.usage[name] = u
+	}
+	return u
+}
+
+// resetUsage ...
+// Forgets the counted usage of the given namespaces, or of every namespace when none is given
+// The next write recounts
+func (m *store) resetUsage(names ...string) {
+	m.usageMu.Lock()
+	defer m.usageMu.Unlock()
+	if len(names) == 0 {
+		m.usage = map[string]*usage{}
+	}
+	for _, name := range names {
+		delete(m.usage, name)
+	}
+}
+
+// write ...
+// badger read-write transaction of the namespace keeping it within its quota and the store within its cap
+// fn reports its writes in d, d is nil when neither a quota nor a cap applies
+// Writes under a cap run one at a time, keys evicted to make room are deleted in the same transaction
+// return ErrQuotaExceeded or ErrStoreFull when the change does not fit
+func (m *memdb) write(fn func(txn *badger.Txn, d *change) error) error {
+	q := m.cfg.QuotaFor(m.ns)
+	quota := q.MaxKeys > 0 || q.MaxBytes > 0
+	if !quota && m.tracker == nil {
+		return m.update(func(txn *badger.Txn) error { return fn(txn, nil) })
+	}
+	var u *usage
+	if quota {
+		u = m.usageOf(m.ns)
+		u.mu.Lock()
+		defer u.mu.Unlock()
+	}
+	if m.tracker != nil {
+		m.tracker.writeMu.Lock()
+		defer m.tracker.writeMu.Unlock()
+	}
+	var d change
+	err := m.update(func(txn *badger.Txn) error {
+		d = change{}
+		if err := fn(txn, &d); err != nil {
+			return err
+		}
+		if quota {
+			// counted keys may have expired since, recount before refusing the write
+			if !u.known || u.exceeds(d, q.MaxKeys, q.MaxBytes) {
+				total, err := m.count()
+				if err != nil {
+					return err
+				}
+				u.total, u.known = total, true
+			}
+			if u.exceeds(d, q.MaxKeys, q.MaxBytes) {
+				return ErrQuotaExceeded
+			}
+		}
+		if m.tracker != nil {
+			return m.tracker.makeRoom(m.db, txn, &d)
+		}
+		return nil
+	})
+	if err == ErrStoreFull {
+		metricRejected.Add(1)
+	}
+	if err != nil {
+		return err
+	}
+	if quota {
+		u.total.keys += d.keys
+		u.total.bytes += d.bytes
+	}
+	if m.tracker != nil {
+		m.tracker.apply(d)
+	}
+	return nil
+}
+
+// count ...
+// Counts the live keys and their bytes in the namespace without reading values
+// Runs its own snapshot, the caller already holds the store read lock
+func (m *memdb) count() (change, error) {
+	var total change
+	err := m.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = m.prefix
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			total.keys++
+			total.bytes += m.size(it.Item())
+		}
+		return nil
+	})
+	return total, err
+}
+
+// size ...
+// Bytes of the namespace key and the value of a stored item
+func (m *memdb) size(item *badger.Item) int64 {
+	return int64(len(item.Key())-len(m.prefix)) + item.ValueSize()
+}
+
+// setEntry ...
+// Writes e in txn and adds the usage change to d, if tracked
+// Stores keeping the key history prefix the value with its write time
+func (m *memdb) setEntry(txn *badger.Txn, d *change, e *badger.Entry) error {
+	if m.cfg.NumVersionsToKeep > 1 {
+		e.Value = stamp(e.Value, time.Now())
+		e.UserMeta |= metaStamped
+	}
+	if d != nil {
+		d.written = append(d.written, tracked{key: string(e.Key), size: int64(len(e.Key) + len(e.Value)), expiresAt: e.ExpiresAt})
+		item, err := txn.Get(e.Key)
+		switch err {
+		case nil:
+			d.bytes -= m.size(item)
+		case badger.ErrKeyNotFound:
+			d.keys++
+		default:
+			return err
+		}
+		d.bytes += int64(len(e.Key)-len(m.prefix)) + int64(len(e.Value))
+	}
+	return txn.SetEntry(e)
+}
@@ -0,0 +1,122 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getircase/config"
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemDbNamespace(t *testing.T) {
+	mgr := newMemDB(t)
+	red, err := mgr.Namespace("team-red")
+	require.NoError(t, err)
+	blue, err := mgr.Namespace("team-blue")
+	require.NoError(t, err)
+	_, err = mgr.Namespace("no/slashes")
+	require.Equal(t, db.ErrBadNamespace, err)
+
+	// the same key is separate in every namespace
+	require.NoError(t, mgr.SetKV("active-tabs", "0"))
+	require.NoError(t, red.SetKV("active-tabs", "1"))
+	require.NoError(t, blue.SetKV("active-tabs", "2"))
+	n, err := red.Incr("active-tabs", 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(11), n)
+	out, err := blue.Retrieve("active-tabs")
+	require.NoError(t, err)
+	require.Equal(t, "2", out.Value.String())
+	require.Equal(t, "active-tabs", out.Key)
+	_, err = red.Retrieve("")
+	require.Equal(t, db.ErrEmptyKey, err)
+
+	// listings stay inside the namespace
+	list, err := mgr.List("", 0, "", true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"active-tabs"}, list.Keys)
+	require.Equal(t, "0", list.Values["active-tabs"].String())
+	require.NoError(t, red.SetKV("b", "x"))
+	list, err = red.List("", 1, "", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"active-tabs"}, list.Keys)
+	list, err = red.List("", 1, list.Cursor, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, list.Keys)
+	require.Empty(t, list.Cursor)
+
+	// the default namespace can not reach into named ones
+	require.Equal(t, db.ErrReservedKey, mgr.SetKV("\x00team-red\x00b", "y"))
+	_, err = mgr.Retrieve("\x00team-red\x00b")
+	require.Equal(t, db.ErrReservedKey, err)
+	_, err = mgr.List("\x00", 0, "", false)
+	require.Equal(t, db.ErrReservedKey, err)
+
+	names, err := mgr.Namespaces()
+	require.NoError(t, err)
+	require.Equal(t, []string{"team-blue", "team-red"}, names)
+
+	// dropping a namespace leaves the others alone
+	require.NoError(t, mgr.DropNamespace("team-red"))
+	_, err = red.Retrieve("active-tabs")
+	require.Equal(t, db.ErrKeyNotFound, err)
+	found, err := blue.Exists("active-tabs")
+	require.NoError(t, err)
+	require.True(t, found)
+	names, err = mgr.Namespaces()
+	require.NoError(t, err)
+	require.Equal(t, []string{"team-blue"}, names)
+	// closing a namespace keeps the store open
+	require.NoError(t, blue.Close())
+	_, err = mgr.Retrieve("active-tabs")
+	require.NoError(t, err)
+}
+
+func TestInMemDbNamespaceQuota(t *testing.T) {
+	cfg := config.Default().MemDB
+	cfg.LogLevel = "error"
+	cfg.NamespaceQuota = config.Quota{MaxKeys: 2}
+	cfg.Namespaces = map[string]config.Quota{"small": {MaxBytes: 10}}
+	mgr, err := db.NewMemDBManager(cfg)
+	require.NoError(t, err)
+	defer mgr.Close()
+
+	ns, err := mgr.Namespace("limited")
+	require.NoError(t, err)
+	require.NoError(t, ns.SetKV("a", "1"))
+	require.NoError(t, ns.SetKV("b", "1"))
+	// overwriting does not add a key, a third key does
+	require.NoError(t, ns.SetKV("b", "2"))
+	require.Equal(t, db.ErrQuotaExceeded, ns.SetKV("c", "1"))
+	_, err = ns.Incr("c", 1)
+	require.Equal(t, db.ErrQuotaExceeded, err)
+	require.Equal(t, db.ErrQuotaExceeded, ns.SetBatch([]models.InMemory{{Key: "a", Value: models.Text("2")}, {Key: "c", Value: models.Text("3")}}))
+	// deleting frees room
+	require.NoError(t, ns.Delete("a"))
+	require.NoError(t, ns.SetKV("c", "1"))
+	// expired keys are not counted once the quota is reached
+	require.NoError(t, ns.Delete("c"))
+	require.NoError(t, ns.SetKVWithTTL("c", "1", time.Second))
+	time.Sleep(2 * time.Second)
+	require.NoError(t, ns.SetKV("d", "1"))
+
+	// per namespace override counts key and value bytes
+	small, err := mgr.Namespace("small")
+	require.NoError(t, err)
+	require.NoError(t, small.SetKV("key", "value"))
+	require.Equal(t, db.ErrQuotaExceeded, small.SetKV("k", "vv"))
+	require.NoError(t, small.SetKV("key", "v"))
+	require.NoError(t, small.SetKV("k", "vv"))
+
+	// the default namespace is unlimited
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, mgr.SetKV(k, "1"))
+	}
+
+	// invalid override names are refused at startup
+	cfg.Namespaces = map[string]config.Quota{"bad name": {}}
+	_, err = db.NewMemDBManager(cfg)
+	require.Error(t, err)
+}
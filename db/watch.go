@@ -0,0 +1,312 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	"github.com/getircase/models"
+)
+
+// watchBuffer ...
+// Events a watcher may fall behind before it is dropped
+// badger blocks every write while a subscriber callback blocks,
+// so a slow consumer must never stall the callback
+const watchBuffer = 1024
+
+// ErrWatchOverflow ...
+// Reported by a watcher whose consumer fell more than watchBuffer events behind
+// or by Watch when more than watchBuffer keys changed after the version to replay from
+var ErrWatchOverflow = errors.New("watcher fell behind, resubscribe")
+
+// Watcher ...
+// Stream of changes under a key prefix
+// Events is closed when the watch ends, Err then tells why
+type Watcher struct {
+	events chan models.KVEvent
+	err    error
+}
+
+// Events ...
+// Changes in commit order, closed when the watch ends
+func (w *Watcher) Events() <-chan models.KVEvent { return w.events }
+
+// Err ...
+// Reason the watch ended, only valid once Events is closed
+func (w *Watcher) Err() error { return w.err }
+
+// badgerPrefix ...
+// Prefix of the internal keys badger publishes alongside user writes, it refuses it on user keys
+var badgerPrefix = []byte("!badger!")
+
+// probeKey ...
+// Key the hub deletes at startup until its subscription sees it, proving the subscription is live
+// It sits under an empty namespace name, which no namespace can have, and is never stored live
+var probeKey = []byte(nsMarker + nsMarker + "watch")
+
+// probeTimeout ...
+// Upper bound for the store subscription to come up at startup
+const probeTimeout = 5 * time.Second
+
+// hub ...
+// Fans the entries published to the one badger subscription of the store out to its watchers
+// badger gives no signal once a subscription is registered, so the store subscribes once at startup
+// and Watch registers with the hub instead, synchronously under mu
+// done is set when the store is closed
+type hub struct {
+	mu      sync.Mutex
+	next    int
+	watches map[int]*watch
+	done    bool
+	ready   chan struct{}
+	once    sync.Once
+}
+
+// watch ...
+// Watcher registered with the hub, live receives the published entries under match
+// overflow is set before live is closed when the consumer fell behind
+type watch struct {
+	match    []byte
+	live     chan *pb.KV
+	overflow bool
+}
+
+// startHub ...
+// Subscribes to every write of db and returns once the subscription delivers
+// return err when it does not come up within probeTimeout
+func startHub(db *badger.DB) (*hub, error) {
+	h := &hub{watches: map[int]*watch{}, ready: make(chan struct{})}
+	go func() {
+		_ = db.Subscribe(context.Background(), h.publish, []pb.Match{{Prefix: nil}})
+		h.close()
+	}()
+	timeout := time.After(probeTimeout)
+	for {
+		// a delete of the absent key is published without storing a live key
+		if err := db.Update(func(txn *badger.Txn) error { return txn.Delete(probeKey) }); err != nil {
+			return nil, fmt.Errorf("badger subscribe: %v", err)
+		}
+		select {
+		case <-h.ready:
+			return h, nil
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			return nil, fmt.Errorf("badger subscribe: not live after %v", probeTimeout)
+		}
+	}
+}
+
+// publish ...
+// Hands the published entries to the watchers of their keys without ever blocking badger,
+// which stalls every write while the callback runs; a full watcher is dropped
+func (h *hub) publish(list *badger.KVList) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, kv := range list.Kv {
+		if bytes.Equal(kv.Key, probeKey) {
+			h.once.Do(func() { close(h.ready) })
+			continue
+		}
+		if bytes.HasPrefix(kv.Key, badgerPrefix) {
+			continue
+		}
+		for id, w := range h.watches {
+			if !bytes.HasPrefix(kv.Key, w.match) {
+				continue
+			}
+			select {
+			case w.live <- kv:
+			default:
+				w.overflow = true
+				close(w.live)
+				delete(h.watches, id)
+			}
+		}
+	}
+	return nil
+}
+
+// add ...
+// Registers a watch of the keys under match, every entry published after add returns reaches it
+// return false when the store is closed
+func (h *hub) add(match []byte) (int, *watch, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		return 0, nil, false
+	}
+	h.next++
+	w := &watch{match: match, live: make(chan *pb.KV, watchBuffer)}
+	h.watches[h.next] = w
+	return h.next, w, true
+}
+
+// remove ...
+// Unregisters the watch, if it is still registered
+func (h *hub) remove(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if w, ok := h.watches[id]; ok {
+		close(w.live)
+		delete(h.watches, id)
+	}
+}
+
+// close ...
+// Ends every watch once the store subscription ended with the store
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.done = true
+	for id, w := range h.watches {
+		close(w.live)
+		delete(h.watches, id)
+	}
+}
+
+// Watch ...
+// Watches sets and deletes of keys under prefix, registered before Watch returns
+// so every write committed afterwards is delivered
+// since above 0 first replays the keys changed after that version, oldest first, with their
+// current value or as deleted; intermediate versions and deletes badger already compacted are not replayed
+// The watch runs until ctx is done, the store is closed or the consumer falls behind
+// Expiry of a TTL key produces no event
+// The default namespace does not see the keys of named namespaces
+// return ErrReservedKey for a prefix hiding in the namespaces of the default one
+// and ErrWatchOverflow when more than watchBuffer keys changed after since
+func (m *memdb) Watch(ctx context.Context, prefix string, since uint64) (*Watcher, error) {
+	if m.ns == "" && strings.HasPrefix(prefix, nsMarker) {
+		return nil, ErrReservedKey
+	}
+	w := &Watcher{events: make(chan models.KVEvent, watchBuffer)}
+	match := append(append([]byte{}, m.prefix...), prefix...)
+	id, sub, ok := m.hub.add(match)
+	if !ok {
+		close(w.events)
+		return w, nil
+	}
+	var backlog []models.KVEvent
+	var readTs uint64
+	if since > 0 {
+		var err error
+		if backlog, readTs, err = m.changedSince(match, since); err != nil {
+			m.hub.remove(id)
+			return nil, err
+		}
+	}
+	go func() {
+		defer close(w.events)
+		defer m.hub.remove(id)
+		for _, ev := range backlog {
+			select {
+			case w.events <- ev:
+			case <-ctx.Done():
+				w.err = ctx.Err()
+				return
+			}
+		}
+		for {
+			select {
+			case kv, ok := <-sub.live:
+				if !ok {
+					if sub.overflow {
+						w.err = ErrWatchOverflow
+					}
+					return
+				}
+				// the replay already holds what was committed up to its snapshot
+				if kv.Version <= readTs || m.ns == "" && bytes.HasPrefix(kv.Key, []byte(nsMarker)) {
+					continue
+				}
+				select {
+				case w.events <- m.toEvent(kv):
+				case <-ctx.Done():
+					w.err = ctx.Err()
+					return
+				}
+			case <-ctx.Done():
+				w.err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+// changedSince ...
+// Events of the keys under match whose newest version is above since, oldest first,
+// and the read timestamp of the snapshot they were read at
+// Expired keys are left out, deleted ones reported as deletes
+// return ErrWatchOverflow when more than watchBuffer keys changed
+func (m *memdb) changedSince(match []byte, since uint64) ([]models.KVEvent, uint64, error) {
+	var events []models.KVEvent
+	var readTs uint64
+	err := m.view(func(txn *badger.Txn) error {
+		readTs = txn.ReadTs()
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = match
+		opts.AllVersions = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		var last []byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			// versions of a key come newest first, only the newest counts
+			if last != nil && bytes.Equal(item.Key(), last) {
+				continue
+			}
+			last = item.KeyCopy(last)
+			if item.Version() <= since || m.ns == "" && bytes.HasPrefix(last, []byte(nsMarker)) {
+				continue
+			}
+			meta := item.UserMeta()
+			if meta&metaLive != 0 && item.IsDeletedOrExpired() {
+				continue
+			}
+			kv := &pb.KV{Key: item.KeyCopy(nil), Meta: []byte{meta}, ExpiresAt: item.ExpiresAt(), Version: item.Version()}
+			if meta&metaLive != 0 {
+				v, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				kv.Value = v
+			}
+			if len(events) == watchBuffer {
+				return ErrWatchOverflow
+			}
+			events = append(events, m.toEvent(kv))
+		}
+		return nil
+	})
+	sort.Slice(events, func(i, j int) bool { return events[i].Version < events[j].Version })
+	return events, readTs, err
+}
+
+// toEvent ...
+// Maps a published badger entry to a KVEvent keyed in the namespace
+func (m *memdb) toEvent(kv *pb.KV) models.KVEvent {
+	ev := models.KVEvent{Key: string(kv.Key[len(m.prefix):]), Version: models.Version(kv.Version)}
+	if len(kv.Meta) == 0 || kv.Meta[0]&metaLive == 0 {
+		ev.Type = models.EventDelete
+		return ev
+	}
+	ev.Type = models.EventSet
+	// a value that fails to decompress is reported empty
+	val, err := plain(kv.Value, kv.Meta[0])
+	if err != nil {
+		val = nil
+	}
+	ev.Value = decode(val, kv.Meta[0])
+	ev.ContentType = blobType(val, kv.Meta[0])
+	if kv.ExpiresAt > 0 {
+		ev.TTL = models.TTL(remaining(kv.ExpiresAt))
+	}
+	return ev
+}
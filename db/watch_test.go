@@ -0,0 +1,151 @@
+package db_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/getircase/db"
+	"github.com/getircase/models"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent ...
+// Writes once with write and waits for the watcher to deliver the event
+// the watch is registered once Watch returns, so a single write must arrive
+func nextEvent(t *testing.T, events <-chan models.KVEvent, write func()) models.KVEvent {
+	write()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return models.KVEvent{}
+}
+
+func TestInMemDbWatch(t *testing.T) {
+	mgr := newMemDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher, err := mgr.Watch(ctx, "tabs/", 0)
+	require.NoError(t, err)
+
+	ev := nextEvent(t, watcher.Events(), func() { require.NoError(t, mgr.SetKVWithTTL("tabs/1", "open", time.Hour)) })
+	require.Equal(t, models.EventSet, ev.Type)
+	require.Equal(t, "tabs/1", ev.Key)
+	require.Equal(t, "open", ev.Value.String())
+	require.NotZero(t, ev.Version)
+	require.NotZero(t, ev.TTL)
+
+	// keys outside the prefix are not delivered, deletes are
+	require.NoError(t, mgr.SetKV("users/1", "x"))
+	require.NoError(t, mgr.Delete("tabs/1"))
+	ev = <-watcher.Events()
+	require.Equal(t, models.EventDelete, ev.Type)
+	require.Equal(t, "tabs/1", ev.Key)
+	require.Empty(t, ev.Value)
+
+	// an empty value is still a set
+	require.NoError(t, mgr.SetKV("tabs/2", ""))
+	ev = <-watcher.Events()
+	require.Equal(t, models.EventSet, ev.Type)
+
+	// cancelling ends the watch
+	cancel()
+	for range watcher.Events() {
+	}
+	require.Equal(t, context.Canceled, watcher.Err())
+}
+
+func TestInMemDbWatchNamespace(t *testing.T) {
+	mgr := newMemDB(t)
+	ns, err := mgr.Namespace("team-red")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all, err := mgr.Watch(ctx, "", 0)
+	require.NoError(t, err)
+	red, err := ns.Watch(ctx, "tabs/", 0)
+	require.NoError(t, err)
+
+	// keys come back without the namespace prefix
+	ev := nextEvent(t, red.Events(), func() { require.NoError(t, ns.SetKV("tabs/1", "open")) })
+	require.Equal(t, "tabs/1", ev.Key)
+	// the default namespace never sees named ones
+	ev = nextEvent(t, all.Events(), func() { require.NoError(t, mgr.SetKV("tabs/1", "default")) })
+	require.Equal(t, "tabs/1", ev.Key)
+	require.Equal(t, "default", ev.Value.String())
+	for len(all.Events()) > 0 {
+		require.Equal(t, "default", (<-all.Events()).Value.String())
+	}
+
+	_, err = mgr.Watch(ctx, "\x00team-red", 0)
+	require.Equal(t, db.ErrReservedKey, err)
+}
+
+func TestInMemDbWatchNoMissedWrites(t *testing.T) {
+	mgr := newMemDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// every watch sees the write right after it, however many are opened
+	for i := 0; i < 50; i++ {
+		watcher, err := mgr.Watch(ctx, "tabs/", 0)
+		require.NoError(t, err)
+		require.NoError(t, mgr.SetKV("tabs/1", strconv.Itoa(i)))
+		select {
+		case ev := <-watcher.Events():
+			require.Equal(t, strconv.Itoa(i), ev.Value.String())
+		case <-time.After(5 * time.Second):
+			t.Fatal("write missed by a new watch")
+		}
+	}
+}
+
+func TestInMemDbWatchSince(t *testing.T) {
+	mgr := newMemDB(t)
+	ns, err := mgr.Namespace("team-red")
+	require.NoError(t, err)
+	require.NoError(t, mgr.SetKV("tabs/1", "old"))
+	out, err := mgr.Retrieve("tabs/1")
+	require.NoError(t, err)
+	since := uint64(out.Version)
+	require.NoError(t, mgr.SetKV("tabs/2", "open"))
+	require.NoError(t, mgr.SetKV("tabs/3", "open"))
+	require.NoError(t, mgr.Delete("tabs/3"))
+	require.NoError(t, mgr.SetKV("tabs/1", "new"))
+	require.NoError(t, mgr.SetKV("users/1", "x"))
+	require.NoError(t, ns.SetKV("tabs/9", "red"))
+	require.NoError(t, mgr.SetKVWithTTL("tabs/4", "gone", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	// keys changed after since are replayed oldest first with their current state, then live writes follow
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := mgr.Watch(ctx, "tabs/", since)
+	require.NoError(t, err)
+	require.NoError(t, mgr.SetKV("tabs/5", "live"))
+	var got []string
+	var versions []models.Version
+	for len(got) < 4 {
+		select {
+		case ev := <-watcher.Events():
+			got = append(got, string(ev.Type)+" "+ev.Key+" "+ev.Value.String())
+			versions = append(versions, ev.Version)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("missing events after %v", got)
+		}
+	}
+	require.Equal(t, []string{"set tabs/2 open", "delete tabs/3 ", "set tabs/1 new", "set tabs/5 live"}, got)
+	for i := 1; i < len(versions); i++ {
+		require.Less(t, uint64(versions[i-1]), uint64(versions[i]))
+	}
+	require.Empty(t, watcher.Events())
+
+	// more changed keys than a watcher buffers are refused before the watch starts
+	for i := 0; i <= 1024; i++ {
+		require.NoError(t, mgr.SetKV("many/"+strconv.Itoa(i), "x"))
+	}
+	_, err = mgr.Watch(ctx, "many/", since)
+	require.Equal(t, db.ErrWatchOverflow, err)
+}
@@ -1,29 +1,117 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
+	// timezones of /mongo requests resolve on hosts without a zoneinfo database
+	_ "time/tzdata"
 
+	"github.com/getircase/config"
 	"github.com/getircase/controller"
+	"github.com/getircase/db"
 )
 
 func main() {
-	var memServer, mongoServer http.Handler
-	memServer = new(controller.MemDbGate)
-	mongoServer = new(controller.MongoDbGate)
-	// Add request handlers for the given url path
-	http.Handle("/in-memory", memServer)
-	http.Handle("/mongo", mongoServer)
-	// serve http requests on 8080
-	log.Fatal(http.ListenAndServe(":8080", nil))
-	// Run the server indefinitely
-	// till os kills or user interrupt
-	done := make(chan os.Signal, 2)
-	signal.Notify(done, os.Interrupt)
-	signal.Notify(done, os.Kill)
-	_ = <-done
+	// Subcommands talk to a running server and exit
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:])
+			if err != nil && err != flag.ErrHelp {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	// Load configuration from file, environment and flags
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("startup: %v", err)
+	}
+	if err = run(cfg); err != nil {
+		log.Fatal(err)
+	}
 	fmt.Printf("\nThats all folks.\n")
 }
+
+// run ...
+// Wires the stores into the gates and serves http requests
+// till os terminates or user interrupts, then drains in-flight requests
+// within the shutdown timeout and releases the stores
+func run(cfg *config.Config) error {
+	// Construct the stores explicitly and hand them to the gates
+	memMgr, err := db.NewMemDBManager(cfg.MemDB)
+	if err != nil {
+		return fmt.Errorf("startup: %v", err)
+	}
+	defer closeStore("memdb", memMgr)
+	mongoMgr, err := db.NewMongoManager(cfg.Mongo)
+	if err != nil {
+		return fmt.Errorf("startup: %v", err)
+	}
+	defer closeStore("mongo", mongoMgr)
+
+	var mongoServer http.Handler
+	memServer := controller.NewMemDbGate(memMgr)
+	mongoServer = controller.NewMongoDbGate(mongoMgr)
+	// Add request handlers for the given url path
+	mux := http.NewServeMux()
+	mux.Handle("/in-memory", memServer)
+	mux.Handle("/in-memory/", memServer)
+	mux.Handle("/mongo", mongoServer)
+	// the admin endpoints can dump and overwrite the store, they are only mounted behind a token
+	if cfg.Server.AdminToken != "" {
+		mux.Handle("/admin/", controller.NewAdminGate(memMgr, cfg.Server.AdminToken))
+	} else {
+		log.Printf("server.adminToken not set, admin endpoints disabled")
+	}
+
+	srv := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      mux,
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout),
+	}
+	// watch streams and WebSockets never finish on their own, end them when shutdown starts
+	srv.RegisterOnShutdown(memServer.CloseWatchers)
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("serving http on %s", cfg.Server.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(done)
+	select {
+	case err = <-serveErr:
+		return fmt.Errorf("http server: %v", err)
+	case sig := <-done:
+		log.Printf("received %v, shutting down", sig)
+	}
+	// Stop accepting connections and wait for active requests to complete
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("http shutdown: %v", err)
+	}
+	return nil
+}
+
+// closeStore ...
+// Releases a store on the way out, failures are only logged
+func closeStore(name string, store interface{ Close() error }) {
+	if err := store.Close(); err != nil {
+		log.Printf("close %s: %v", name, err)
+	}
+}
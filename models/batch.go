@@ -0,0 +1,9 @@
+package models
+
+// InMemoryBatch ...
+// Model for the in-memory multi-get http response
+// Records holds the stored keys in request order, Missing the keys not found
+type InMemoryBatch struct {
+	Records []InMemory `json:"records"`
+	Missing []string   `json:"missing"`
+}
@@ -0,0 +1,11 @@
+package models
+
+// Blob ...
+// Raw bytes of an in-memory key with the content type they were stored with
+// Version and TTL are reported on reads like for InMemory
+type Blob struct {
+	ContentType string
+	Data        []byte
+	Version     Version
+	TTL         TTL
+}
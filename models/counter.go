@@ -0,0 +1,10 @@
+package models
+
+// Counter ...
+// Model for in-memory counter http requests and responses
+// Delta defaults to 1 when omitted, Value holds the counter after the update
+type Counter struct {
+	Key   string `json:"key"`
+	Delta *int64 `json:"delta,omitempty"`
+	Value int64  `json:"value"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// Types of KVEvent
+const (
+	EventSet    = "set"
+	EventDelete = "delete"
+)
+
+// KVEvent ...
+// Model for a change of an in-memory key pushed to watchers
+// Value and TTL are empty for deletes, ContentType is only set for raw values
+type KVEvent struct {
+	Type        string  `json:"type"`
+	Key         string  `json:"key"`
+	Value       Value   `json:"value,omitempty"`
+	ContentType string  `json:"contentType,omitempty"`
+	Version     Version `json:"version"`
+	TTL         TTL     `json:"ttl,omitempty"`
+}
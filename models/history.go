@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// KeyVersion ...
+// Model for one stored version of an in-memory key
+// Time is the write time, only recorded when the store keeps more than one version
+// Deleted versions have no value, ContentType is only set for raw values
+type KeyVersion struct {
+	Version     Version    `json:"version"`
+	Value       Value      `json:"value,omitempty"`
+	ContentType string     `json:"contentType,omitempty"`
+	Time        *time.Time `json:"time,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+}
+
+// KeyHistory ...
+// Model for the versions of an in-memory key still kept by the store, newest first
+type KeyHistory struct {
+	Key      string       `json:"key"`
+	Versions []KeyVersion `json:"versions"`
+}
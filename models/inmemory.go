@@ -2,7 +2,16 @@ package models
 
 // InMemory ...
 // Model for MongoDb http requests
+// Value is any JSON document, strings stay the common case
+// TTL is optional, keys without TTL never expire
+// Version is reported on reads, ExpectedVersion makes a write conditional
+// (0 means the key must not exist yet)
+// ContentType is only reported for raw values, their Value holds the base64 of the bytes
 type InMemory struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key             string   `json:"key"`
+	Value           Value    `json:"value"`
+	ContentType     string   `json:"contentType,omitempty"`
+	TTL             TTL      `json:"ttl,omitempty"`
+	Version         Version  `json:"version,omitempty"`
+	ExpectedVersion *Version `json:"expectedVersion,omitempty"`
 }
@@ -0,0 +1,10 @@
+package models
+
+// KeyList ...
+// Model for a page of the in-memory key listing
+// Values is only filled when requested, Cursor is empty on the last page
+type KeyList struct {
+	Keys   []string         `json:"keys"`
+	Values map[string]Value `json:"values,omitempty"`
+	Cursor string           `json:"cursor,omitempty"`
+}
@@ -2,9 +2,26 @@ package models
 
 // MongoRequest ...
 // Model for MongoRequest http requests
+// Every bound is optional, an omitted one leaves its side of the range open
+// Bounds are exclusive unless the matching Inclusive field is set
+// Dates are calendar dates (YYYY-MM-DD) read in Timezone, RFC 3339 timestamps or relative like now-7d
+// Timezone is an IANA name like Europe/Istanbul, UTC when omitted
+// Limit is the page size (default 100, at most 1000), Page selects a page counted from 1
+// and Cursor continues after the page that returned it as nextCursor; Page and Cursor are exclusive
+// Sort is createdAt (default), totalCount or key, Order is asc (default) or desc
 type MongoRequest struct {
-	StartDate string  `json:"startDate"`
-	EndDate   string  `json:"endDate"`
-	MinCount  float64 `json:"minCount"`
-	MaxCount  float64 `json:"maxCount"`
+	StartDate      string   `json:"startDate,omitempty"`
+	EndDate        string   `json:"endDate,omitempty"`
+	Timezone       string   `json:"timezone,omitempty"`
+	MinCount       *float64 `json:"minCount,omitempty"`
+	MaxCount       *float64 `json:"maxCount,omitempty"`
+	StartInclusive bool     `json:"startInclusive,omitempty"`
+	EndInclusive   bool     `json:"endInclusive,omitempty"`
+	MinInclusive   bool     `json:"minInclusive,omitempty"`
+	MaxInclusive   bool     `json:"maxInclusive,omitempty"`
+	Limit          int      `json:"limit,omitempty"`
+	Page           int      `json:"page,omitempty"`
+	Cursor         string   `json:"cursor,omitempty"`
+	Sort           string   `json:"sort,omitempty"`
+	Order          string   `json:"order,omitempty"`
 }
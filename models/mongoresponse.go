@@ -4,8 +4,11 @@ import "go.mongodb.org/mongo-driver/bson"
 
 // MongoResponse ...
 // Model for MongoDb http response
+// Total counts every matching record, NextCursor is set when more records follow the page
 type MongoResponse struct {
-	Code    int      `json:"code"`
-	Msg     string   `json:"msg"`
-	Records []bson.M `json:"records"`
+	Code       int      `json:"code"`
+	Msg        string   `json:"msg"`
+	Total      int64    `json:"total"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+	Records    []bson.M `json:"records"`
 }
@@ -0,0 +1,37 @@
+package models
+
+// Operations of the WebSocket protocol
+const (
+	SocketGet     = "get"
+	SocketSet     = "set"
+	SocketDelete  = "delete"
+	SocketWatch   = "watch"
+	SocketUnwatch = "unwatch"
+	SocketEvent   = "event"
+)
+
+// SocketRequest ...
+// Model for a command sent over the in-memory WebSocket
+// ID is echoed in the response, for watch it also names the subscription
+// Path is an optional JSON pointer into the value read by get
+type SocketRequest struct {
+	ID     string `json:"id,omitempty"`
+	Op     string `json:"op"`
+	Key    string `json:"key,omitempty"`
+	Value  Value  `json:"value,omitempty"`
+	TTL    TTL    `json:"ttl,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// SocketResponse ...
+// Model for a command result or a watch event sent over the in-memory WebSocket
+// Code follows the http status the REST endpoint answers for the same outcome
+type SocketResponse struct {
+	ID     string    `json:"id,omitempty"`
+	Op     string    `json:"op"`
+	Code   int       `json:"code"`
+	Error  string    `json:"error,omitempty"`
+	Record *InMemory `json:"record,omitempty"`
+	Event  *KVEvent  `json:"event,omitempty"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TTL ...
+// Time to live of an in-memory key
+// Accepted as a number of seconds (60) or a Go duration string ("90s", "1h30m")
+// Zero means the key never expires
+type TTL time.Duration
+
+// Duration ...
+// Returns the TTL as time.Duration
+func (t TTL) Duration() time.Duration { return time.Duration(t) }
+
+// MarshalJSON ...
+// Writes the TTL as a Go duration string
+func (t TTL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(t).String())
+}
+
+// UnmarshalJSON ...
+// Reads seconds or a Go duration string, negative values are rejected
+func (t *TTL) UnmarshalJSON(b []byte) error {
+	var d time.Duration
+	var raw interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case nil:
+		d = 0
+	case float64:
+		d = time.Duration(v * float64(time.Second))
+	case string:
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid ttl %q: %v", v, err)
+		}
+	default:
+		return fmt.Errorf("ttl must be seconds or a duration string")
+	}
+	if d < 0 {
+		return fmt.Errorf("ttl cannot be negative")
+	}
+	*t = TTL(d)
+	return nil
+}
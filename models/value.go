@@ -0,0 +1,124 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by Value.Pointer
+var (
+	// ErrBadPointer ...
+	// Returned when a path is not a JSON pointer
+	ErrBadPointer = errors.New("path must be a JSON pointer like /feature/enabled")
+	// ErrPathNotFound ...
+	// Returned when a JSON pointer does not resolve inside the value
+	ErrPathNotFound = errors.New("path not found in value")
+)
+
+// Value ...
+// Value of an in-memory key, any JSON document kept as raw compact JSON
+// Strings are the common case and read back as strings, objects and arrays are
+// written back unescaped
+type Value []byte
+
+// Text ...
+// Returns the Value holding the string s
+func Text(s string) Value {
+	b, _ := json.Marshal(s)
+	return b
+}
+
+// IsText ...
+// Reports whether the value is a JSON string, an empty Value counts as the empty string
+func (v Value) IsText() bool {
+	return len(v) == 0 || v[0] == '"'
+}
+
+// String ...
+// Returns a string value unquoted and any other document as its JSON text
+func (v Value) String() string {
+	if len(v) == 0 {
+		return ""
+	}
+	var s string
+	if v[0] == '"' && json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	return string(v)
+}
+
+// MarshalJSON ...
+// Writes the raw JSON document, an empty Value as the empty string
+func (v Value) MarshalJSON() ([]byte, error) {
+	if len(v) == 0 {
+		return []byte(`""`), nil
+	}
+	return v, nil
+}
+
+// UnmarshalJSON ...
+// Keeps any JSON document in compact form
+func (v *Value) UnmarshalJSON(b []byte) error {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return err
+	}
+	*v = buf.Bytes()
+	return nil
+}
+
+// Pointer ...
+// Returns the part of the document the RFC 6901 JSON pointer path refers to
+// The empty path returns the whole value
+// return ErrBadPointer for a malformed path and ErrPathNotFound when it does not resolve
+func (v Value) Pointer(path string) (Value, error) {
+	if path == "" {
+		return v, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, ErrBadPointer
+	}
+	doc := json.RawMessage(v)
+	for _, token := range strings.Split(path[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch firstByte(doc) {
+		case '{':
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(doc, &obj); err != nil {
+				return nil, err
+			}
+			next, ok := obj[token]
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			doc = next
+		case '[':
+			var arr []json.RawMessage
+			if err := json.Unmarshal(doc, &arr); err != nil {
+				return nil, err
+			}
+			// array indexes have no sign and no leading zeros
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(arr) || (len(token) > 1 && token[0] == '0') || token[0] == '+' {
+				return nil, ErrPathNotFound
+			}
+			doc = arr[i]
+		default:
+			return nil, ErrPathNotFound
+		}
+	}
+	return Value(doc), nil
+}
+
+// firstByte ...
+// First non space byte of a JSON document
+func firstByte(doc []byte) byte {
+	doc = bytes.TrimLeft(doc, " \t\r\n")
+	if len(doc) == 0 {
+		return 0
+	}
+	return doc[0]
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Version ...
+// Version of an in-memory key
+// Written as a JSON string, read from a string or a number
+type Version uint64
+
+// MarshalJSON ...
+// Writes the version as a decimal JSON string
+func (v Version) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatUint(uint64(v), 10))
+}
+
+// UnmarshalJSON ...
+// Reads a decimal string or a non negative integer
+func (v *Version) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid version %s", b)
+	}
+	*v = Version(n)
+	return nil
+}